@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 Muzz Khan muzxmmilkhxn@gmail.com
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/muzzlol/nomodit/internal/chunk"
+	"github.com/muzzlol/nomodit/internal/prompt"
+	"github.com/muzzlol/nomodit/pkg/llama"
+	"github.com/spf13/cobra"
+)
+
+// cliEditor edits the texts given to the CLI one after the other, writing
+// the results to out and everything else to stderr.
+type cliEditor struct {
+	cmd         *cobra.Command
+	backend     llama.Backend
+	spec        prompt.Spec // for every text, without its Text
+	chunkTokens int         // texts longer than this are edited in parts
+	emb         *embedder
+	out         io.Writer
+}
+
+// edit edits text and writes the result to e.out, followed by a newline.
+func (e *cliEditor) edit(ctx context.Context, text string) error {
+	cmd := e.cmd
+	spec := e.spec
+	spec.Text = text
+	structured := spec.Structured
+	// hold the answer back until it passed the meaning check
+	holdBack := e.emb != nil && e.emb.reject
+
+	job := chunk.Single(spec)
+	if tok, ok := e.backend.(llama.Tokenizer); ok {
+		planned, err := chunk.Plan(ctx, tok, spec, e.chunkTokens)
+		switch {
+		case errors.Is(err, prompt.ErrInputTooLong):
+			return err
+		case err != nil:
+			// an older llama-server without /tokenize, go ahead unchecked
+			log.Printf("could not measure the prompt: %v", err)
+		default:
+			job = planned
+			if job.Tight() {
+				cmd.PrintErrln(warningStyle.Render("Warning: the text barely fits the context, the answer may be cut short"))
+			}
+			if Verbose {
+				cmd.PrintErrln(reasoningStyle.Render(job.String()))
+			}
+		}
+	}
+	var decoder llama.EditDecoder
+	var answer strings.Builder
+
+	var result *llama.Result
+	var err error
+	atLineStart := true // whether the output ended a line, so progress doesn't land mid-line
+	for resp := range job.Run(ctx, e.backend) {
+		if resp.Err != nil {
+			err = resp.Err
+			break
+		}
+		if resp.Chunks > 1 && resp.Content == "" && resp.Reasoning == "" && !resp.Stop {
+			if !atLineStart {
+				cmd.PrintErrln()
+			}
+			cmd.PrintErrln(reasoningStyle.Render(fmt.Sprintf("Editing part %d of %d", resp.Chunk, resp.Chunks)))
+			atLineStart = true
+			continue
+		}
+		if resp.Result != nil {
+			result = resp.Result
+		}
+		if resp.Reasoning != "" {
+			// stderr, so piping stdout only ever captures the answer
+			cmd.PrintErr(reasoningStyle.Render(resp.Reasoning))
+		}
+		if structured {
+			decoder.Write(resp.Content)
+			continue
+		}
+		answer.WriteString(resp.Content)
+		if holdBack {
+			continue
+		}
+		fmt.Fprint(e.out, resp.Content)
+		if resp.Content != "" {
+			atLineStart = strings.HasSuffix(resp.Content, "\n")
+		}
+	}
+	if (ctx.Err() != nil || err != nil) && !atLineStart {
+		cmd.PrintErrln() // the error goes on a line of its own
+	}
+	if ctx.Err() != nil {
+		return errors.New("interrupted")
+	}
+	if err != nil {
+		return err
+	}
+	if Verbose && result != nil {
+		cmd.PrintErrln("\n" + reasoningStyle.Render(result.Summary()))
+	}
+	if structured {
+		edit, err := decoder.Result()
+		if err != nil {
+			return err
+		}
+		if checkMeaning(ctx, cmd, e.emb, text, edit.EditedText) {
+			// a rejected edit leaves the text as it was
+			edit = llama.EditResult{EditedText: text, Edits: []llama.Edit{}}
+		}
+		out, _ := json.MarshalIndent(edit, "", "  ")
+		fmt.Fprintln(e.out, string(out))
+		return nil
+	}
+	edited := answer.String()
+	rejected := checkMeaning(ctx, cmd, e.emb, text, edited)
+	if holdBack {
+		if rejected {
+			edited = text
+		}
+		fmt.Fprint(e.out, edited)
+	}
+	if !strings.HasSuffix(edited, "\n") {
+		fmt.Fprintln(e.out)
+	}
+	return nil
+}
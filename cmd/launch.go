@@ -0,0 +1,69 @@
+/*
+Copyright © 2024 Muzz Khan muzxmmilkhxn@gmail.com
+*/
+package cmd
+
+import (
+	"strings"
+
+	"github.com/muzzlol/nomodit/pkg/config"
+	"github.com/muzzlol/nomodit/pkg/llama"
+	"github.com/spf13/cobra"
+)
+
+var (
+	launchFlags llama.Options
+	llamaArgs   string
+	loraSpecs   []string
+)
+
+// launchOverrides maps each launch flag to the option it overrides.
+var launchOverrides = map[string]func(dst *llama.Options){
+	"llama-server": func(dst *llama.Options) { dst.Binary = launchFlags.Binary },
+	"mmproj":       func(dst *llama.Options) { dst.MMProj = launchFlags.MMProj },
+	"draft":        func(dst *llama.Options) { dst.Draft = launchFlags.Draft },
+	"ctx-size":     func(dst *llama.Options) { dst.CtxSize = launchFlags.CtxSize },
+	"threads":      func(dst *llama.Options) { dst.Threads = launchFlags.Threads },
+	"batch-size":   func(dst *llama.Options) { dst.BatchSize = launchFlags.BatchSize },
+	"parallel":     func(dst *llama.Options) { dst.Parallel = launchFlags.Parallel },
+	"n-gpu-layers": func(dst *llama.Options) { dst.GPULayers = launchFlags.GPULayers },
+	"mlock":        func(dst *llama.Options) { dst.MLock = launchFlags.MLock },
+	"flash-attn":   func(dst *llama.Options) { dst.FlashAttn = launchFlags.FlashAttn },
+	"llama-args":   func(dst *llama.Options) { dst.ExtraArgs = strings.Fields(llamaArgs) },
+}
+
+// resolveLaunchOptions layers the launch flags given on the command line over
+// the configured options for the current LLM and validates the result.
+func resolveLaunchOptions(cmd *cobra.Command) (llama.Options, error) {
+	opts, err := config.LaunchOptions(LLM)
+	if err != nil {
+		return opts, err
+	}
+	for name, override := range launchOverrides {
+		if cmd.Flags().Changed(name) {
+			override(&opts)
+		}
+	}
+	if cmd.Flags().Changed("lora") {
+		if opts.LoRA, err = config.ParseLoRA(loraSpecs); err != nil {
+			return opts, err
+		}
+	}
+	return opts, opts.Validate()
+}
+
+func addLaunchFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&launchFlags.Binary, "llama-server", "", "Path to the llama-server binary (default: llama-server in PATH)")
+	flags.StringVar(&launchFlags.MMProj, "mmproj", "", "Local multimodal projector GGUF to load with the model")
+	flags.StringVar(&launchFlags.Draft, "draft", "", "Draft model for speculative decoding (repo, repo:quant or local .gguf)")
+	flags.IntVar(&launchFlags.CtxSize, "ctx-size", 0, "Context size in tokens (default: the model's)")
+	flags.IntVar(&launchFlags.Threads, "threads", 0, "Threads used for generation")
+	flags.IntVar(&launchFlags.BatchSize, "batch-size", 0, "Logical batch size for prompt processing")
+	flags.IntVar(&launchFlags.Parallel, "parallel", 0, "Number of parallel slots")
+	flags.StringVar(&launchFlags.GPULayers, "n-gpu-layers", "", "Layers to offload to the GPU: a number, auto or all (0 for CPU only)")
+	flags.BoolVar(&launchFlags.MLock, "mlock", false, "Keep the model in RAM instead of letting it be swapped out")
+	flags.StringVar(&launchFlags.FlashAttn, "flash-attn", "", "Flash attention: on, off or auto")
+	flags.StringArrayVar(&loraSpecs, "lora", nil, "LoRA adapter for a task as task=path (repeatable), applied to the instructions asking for that task: grammar, clarity, coherence, simplify, formality, neutralize or paraphrase")
+	flags.StringVar(&llamaArgs, "llama-args", "", "Extra arguments passed to llama-server as is, e.g. \"--no-webui --cache-type-k q8_0\"")
+}
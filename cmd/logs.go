@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 Muzz Khan muzxmmilkhxn@gmail.com
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/muzzlol/nomodit/internal/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logLines  int
+	logFollow bool
+	logServer bool
+	logPath   bool
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show nomodit's and llama-server's logs",
+	Long: `Show the end of nomodit's log, or llama-server's full output with --server.
+Logs are kept in $XDG_STATE_HOME/nomodit (~/.local/state/nomodit by default) on Linux,
+~/Library/Logs/nomodit on macOS and %LOCALAPPDATA%\nomodit\logs on Windows, and rotated
+as they grow. --path prints the location.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := logs.NomoditLog
+		if logServer {
+			name = logs.ServerLog
+		}
+		path, err := logs.Path(name)
+		if err != nil {
+			return err
+		}
+		if logPath {
+			cmd.Println(path)
+			return nil
+		}
+
+		lines, err := logs.Tail(path, logLines)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("nothing logged yet, %s doesn't exist", path)
+		}
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			cmd.Println(line)
+		}
+		if !logFollow {
+			return nil
+		}
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		return logs.Follow(ctx, path, cmd.OutOrStdout())
+	},
+}
+
+// setupLogging sends nomodit's own logs to the rotating log file and opens
+// the one llama-server's output goes to. The returned func closes both.
+func setupLogging(cmd *cobra.Command) (serverLog io.Writer, closeLogs func()) {
+	var closers []io.Closer
+	if f, err := logs.Setup(); err != nil {
+		// never let log output end up on the terminal, it would garble the TUI
+		log.SetOutput(io.Discard)
+		cmd.PrintErrln(dangerStyle.Render("logging disabled: " + err.Error()))
+	} else {
+		closers = append(closers, f)
+	}
+	if f, err := logs.Open(logs.ServerLog); err != nil {
+		log.Printf("llama-server output won't be saved: %v", err)
+	} else {
+		serverLog = f
+		closers = append(closers, f)
+	}
+	return serverLog, func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+}
+
+func init() {
+	logsCmd.Flags().IntVarP(&logLines, "lines", "n", 50, "Number of lines to show")
+	logsCmd.Flags().BoolVarP(&logFollow, "follow", "f", false, "Keep printing lines as they are written")
+	logsCmd.Flags().BoolVar(&logServer, "server", false, "Show llama-server's output instead of nomodit's log")
+	logsCmd.Flags().BoolVar(&logPath, "path", false, "Print the log file's path and exit")
+
+	rootCmd.AddCommand(logsCmd)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 Muzz Khan muzxmmilkhxn@gmail.com
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/muzzlol/nomodit/internal/meaning"
+	"github.com/muzzlol/nomodit/pkg/llama"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// defaultEmbedCtxSize fits long-context embedding models, and documents long
+// enough to be edited in parts. BERT-style models need less, e.g. 512.
+const defaultEmbedCtxSize = 8192
+
+// meaningSettings configure the meaning check.
+type meaningSettings struct {
+	model         string // embed_model
+	url           string // embed_url
+	ctxSize       int    // embed_ctx_size
+	minSimilarity float64
+	reject        bool // reject_meaning_change
+}
+
+// meaningFlags holds the values of the meaning check flags, which only
+// override the config if given.
+var meaningFlags meaningSettings
+
+// resolveMeaning layers the meaning check flags given on the command line
+// over the config.
+func resolveMeaning(cmd *cobra.Command) meaningSettings {
+	return meaningSettings{
+		model:         setting(cmd, "embed-model", meaningFlags.model, viper.GetString),
+		url:           setting(cmd, "embed-url", meaningFlags.url, viper.GetString),
+		ctxSize:       setting(cmd, "embed-ctx-size", meaningFlags.ctxSize, viper.GetInt),
+		minSimilarity: setting(cmd, "min-similarity", meaningFlags.minSimilarity, viper.GetFloat64),
+		reject:        setting(cmd, "reject-meaning-change", meaningFlags.reject, viper.GetBool),
+	}
+}
+
+// embedder is the llama-server running the embedding model for the meaning
+// check. Its Embed waits until the server is ready.
+type embedder struct {
+	meaningSettings
+	server *llama.Server
+	ready  chan struct{}
+	err    error // why the server failed to start, set before ready is closed
+}
+
+// startEmbedder starts the embedding model of settings, or connects to its
+// url. It returns nil when neither is set, which turns the meaning check off.
+// llama-server's binary, GPU layers and log are taken from launchOpts. The server takes texts of up to settings.ctxSize tokens: its
+// context and both batch sizes are that large, since /embedding processes an
+// input in a single batch, and a single slot gets all of it.
+func startEmbedder(launchOpts llama.Options, settings meaningSettings) (*embedder, error) {
+	var server *llama.Server
+	if settings.url != "" {
+		server = llama.NewRemoteServer(settings.url)
+	} else if settings.model != "" {
+		n := settings.ctxSize
+		server = llama.NewServerWithOptions(settings.model, "", llama.Options{
+			Binary:     launchOpts.Binary,
+			GPULayers:  launchOpts.GPULayers,
+			CtxSize:    n,
+			BatchSize:  n,
+			UBatchSize: n,
+			Parallel:   1,
+			Embeddings: true,
+			Log:        launchOpts.Log,
+		})
+	} else {
+		return nil, nil
+	}
+	if err := server.Start(); err != nil {
+		return nil, fmt.Errorf("embedding model: %w", err)
+	}
+
+	e := &embedder{meaningSettings: settings, server: server, ready: make(chan struct{})}
+	go func() {
+		defer close(e.ready)
+		for status := range server.StatusUpdates(context.Background()) {
+			log.Printf("embedding model: %s", status.Message)
+			if status.IsError {
+				e.err = errors.New(status.Message)
+			}
+		}
+	}()
+	return e, nil
+}
+
+// Embed embeds texts once the server is ready.
+func (e *embedder) Embed(ctx context.Context, texts ...string) ([][]float32, error) {
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.server.Embed(ctx, texts...)
+}
+
+// Stop stops the embedding server. It is safe to call on a nil embedder.
+func (e *embedder) Stop() {
+	if e != nil {
+		e.server.Stop()
+	}
+}
+
+// checkMeaning compares the edit with the original, warning on stderr if the
+// meaning seems to have changed. It reports whether the edit should be
+// rejected, as asked by --reject-meaning-change. A check that fails never
+// rejects.
+func checkMeaning(ctx context.Context, cmd *cobra.Command, e *embedder, original, edited string) (rejected bool) {
+	if e == nil {
+		return false
+	}
+	verdict, err := meaning.Check(ctx, e, original, edited, e.minSimilarity)
+	switch {
+	case err != nil:
+		cmd.PrintErrln(warningStyle.Render("Meaning check skipped: " + err.Error()))
+	case verdict.Changed() && e.reject:
+		cmd.PrintErrln(dangerStyle.Render("Edit rejected: " + verdict.String()))
+		return true
+	case verdict.Changed():
+		cmd.PrintErrln(warningStyle.Render("Warning: " + verdict.String()))
+	case Verbose:
+		cmd.PrintErrln(reasoningStyle.Render(verdict.String()))
+	}
+	return false
+}
+
+func addMeaningFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&meaningFlags.model, "embed-model", "", "Embedding model used to check that edits keep the meaning (repo, repo:quant or local .gguf)")
+	flags.StringVar(&meaningFlags.url, "embed-url", "", "Use an already running llama-server with --embeddings at this URL for the meaning check")
+	flags.IntVar(&meaningFlags.ctxSize, "embed-ctx-size", defaultEmbedCtxSize, "Longest text in tokens the embedding model is started to take, at most its training context")
+	flags.Float64Var(&meaningFlags.minSimilarity, "min-similarity", meaning.DefaultThreshold, "Cosine similarity below which an edit is flagged as changing the meaning")
+	flags.BoolVar(&meaningFlags.reject, "reject-meaning-change", false, "Discard edits that fail the meaning check instead of only warning")
+}
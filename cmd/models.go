@@ -0,0 +1,165 @@
+/*
+Copyright © 2024 Muzz Khan muzxmmilkhxn@gmail.com
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/muzzlol/nomodit/pkg/config"
+	"github.com/muzzlol/nomodit/pkg/llama"
+	"github.com/spf13/cobra"
+)
+
+var forceRemove bool
+
+// modelsCmd groups the subcommands that manage llama.cpp's model cache.
+var modelsCmd = &cobra.Command{
+	Use:   "models",
+	Short: "Manage models in the local llama.cpp cache",
+}
+
+var modelsListCmd = &cobra.Command{
+	Use:          "list",
+	Aliases:      []string{"ls"},
+	Short:        "List cached models",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		models, err := llama.ListCachedModels()
+		if err != nil {
+			return err
+		}
+		if len(models) == 0 {
+			cmd.Println("No cached models")
+			return nil
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "REPO\tFILE\tQUANT\tSIZE\tSTATUS")
+		for _, m := range models {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Repo, m.File, orDash(m.Quantization), llama.FormatBytes(m.Size), modelStatus(m))
+		}
+		return w.Flush()
+	},
+}
+
+var modelsPullCmd = &cobra.Command{
+	Use:   "pull <repo>",
+	Short: "Download a model from Hugging Face into the cache",
+	Long: `Download a model from Hugging Face into the cache.
+llama-server does the download, so any -hf form it accepts works, e.g. unsloth/gemma-3-1b-it-GGUF.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		// the configured binary and launch options, so that the server starts
+		// where it would to edit with the model
+		opts, err := config.LaunchOptions(args[0])
+		if err != nil {
+			return err
+		}
+		if err := opts.Validate(); err != nil {
+			return err
+		}
+		serverLog, closeLogs := setupLogging(cmd)
+		defer closeLogs()
+		opts.Log = serverLog
+		server := llama.NewServerWithOptions(args[0], "", opts)
+		if err := server.Start(); err != nil {
+			return err
+		}
+		defer server.Stop()
+		if err := waitReady(ctx, cmd, server); err != nil {
+			return err
+		}
+		cmd.Printf("%s is cached\n", args[0])
+		return nil
+	},
+}
+
+var modelsRemoveCmd = &cobra.Command{
+	Use:          "rm <repo>",
+	Aliases:      []string{"remove"},
+	Short:        "Remove a model's files from the cache",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		removed, err := llama.RemoveCachedModel(args[0], forceRemove)
+		for _, path := range removed {
+			cmd.Printf("removed %s\n", path)
+		}
+		if errors.Is(err, llama.ErrDownloadActive) {
+			return fmt.Errorf("%w\nstop the download first or pass --force", err)
+		}
+		if err != nil {
+			return err
+		}
+		if len(removed) == 0 {
+			return fmt.Errorf("%s is not cached", args[0])
+		}
+		return nil
+	},
+}
+
+var modelsInfoCmd = &cobra.Command{
+	Use:          "info <repo>",
+	Short:        "Show the cached files of a model",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		models, err := llama.FindCachedModels(args[0])
+		if err != nil {
+			return err
+		}
+		if len(models) == 0 {
+			return fmt.Errorf("%s is not cached, run `nomodit models pull %s`", args[0], args[0])
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		for i, m := range models {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "Repo:\t%s\n", m.Repo)
+			fmt.Fprintf(w, "File:\t%s\n", m.File)
+			fmt.Fprintf(w, "Quantization:\t%s\n", orDash(m.Quantization))
+			fmt.Fprintf(w, "Size:\t%s (%d bytes)\n", llama.FormatBytes(m.Size), m.Size)
+			fmt.Fprintf(w, "Modified:\t%s\n", m.ModTime.Format("2006-01-02 15:04"))
+			fmt.Fprintf(w, "Status:\t%s\n", modelStatus(m))
+			fmt.Fprintf(w, "Path:\t%s\n", m.Path)
+		}
+		return w.Flush()
+	},
+}
+
+func modelStatus(m llama.CachedModel) string {
+	switch {
+	case m.Active():
+		return "downloading"
+	case m.Downloading:
+		return "incomplete"
+	default:
+		return "ready"
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	modelsRemoveCmd.Flags().BoolVarP(&forceRemove, "force", "f", false, "Also remove downloads that still look active")
+
+	modelsCmd.AddCommand(modelsListCmd, modelsPullCmd, modelsRemoveCmd, modelsInfoCmd)
+	rootCmd.AddCommand(modelsCmd)
+}
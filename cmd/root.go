@@ -4,10 +4,19 @@ Copyright © 2024 Muzz Khan muzxmmilkhxn@gmail.com
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"unicode"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/muzzlol/nomodit/internal/chunk"
+	"github.com/muzzlol/nomodit/internal/prompt"
+	"github.com/muzzlol/nomodit/internal/textio"
 	"github.com/muzzlol/nomodit/internal/tui"
 	"github.com/muzzlol/nomodit/pkg/config"
 	"github.com/muzzlol/nomodit/pkg/llama"
@@ -16,63 +25,255 @@ import (
 )
 
 var (
-	LLM         string
-	Instruction string = ""
-	dangerStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("124"))
+	LLM            string
+	Port           string
+	Mode           string
+	Verbose        bool
+	ServerURL      string
+	Instruction    string = ""
+	inputFiles     []string
+	outPath        string
+	structuredFlag bool
+	chunkTokens    int
+	cachePrompt    bool
+	dangerStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("124"))
+	warningStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	reasoningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "cli := nomodit [flags] [\"text\"] \n  tui := nomodit [flags]",
+	Use:   "cli := nomodit [flags] [\"text\" | -]... [--file path]... \n  pipe := command | nomodit [flags]\n  tui := nomodit [flags]",
 	Short: "Nomodit is a CLI/TUI for inferencing LLMs for language tasks",
 	Long: `Nomodit is a CLI/TUI for inferencing LLMs for language tasks.
 It allows you to use the nomodit series of models ( more about it here: https://github.com/muzzlol/nomodit ) and also any other model that supports the GGUF format.
 	`,
-	PreRun: func(cmd *cobra.Command, args []string) {
+	Args:                       textArgs,
+	SuggestionsMinimumDistance: 2,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		if err := config.Load(); err != nil {
 			fmt.Printf("failed to load config: %v, \nusing default values: llm: %v\n", err, LLM)
 		}
 		if cmd.Flags().Changed("llm") {
+			// save local models by absolute path so the config works from any directory
+			if ref, err := llama.ParseModelRef(LLM); err == nil && ref.Local() {
+				LLM = ref.Path
+			}
 			viper.Set("llm", LLM)
 			if err := config.Save(); err != nil {
 				fmt.Printf("failed to save config: %v\n", err)
 			}
 		}
 	},
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 0 {
-			tui.Launch(Instruction)
-		} else {
-			if args[0] == "" {
-				cmd.PrintErrln(dangerStyle.Render("Please provide some text to edit."))
-				return
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// from here on errors are about the run, not how nomodit was called
+		cmd.SilenceUsage = true
+		// stop llama-server on SIGINT/SIGTERM instead of leaving it orphaned
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		sampling, err := resolveSampling(cmd)
+		if err != nil {
+			return err
+		}
+
+		mode, err := prompt.ResolveMode(modelSetting(cmd, "mode", Mode), LLM)
+		if err != nil {
+			return err
+		}
+
+		launchOpts, err := resolveLaunchOptions(cmd)
+		if err != nil {
+			return err
+		}
+		inputs, err := textio.Read(textio.Sources{
+			Args:       args,
+			Files:      inputFiles,
+			Stdin:      os.Stdin,
+			StdinPiped: stdinPiped(),
+		})
+		if err != nil {
+			return err
+		}
+		interactive := len(inputs) == 0
+
+		serverLog, closeLogs := setupLogging(cmd)
+		defer closeLogs()
+		launchOpts.Log = serverLog
+
+		var backend llama.Backend = llama.NewServerWithOptions(LLM, Port, launchOpts)
+		adapters := launchOpts.LoRA
+		serverURL := setting(cmd, "server-url", ServerURL, viper.GetString)
+		structured := setting(cmd, "structured", structuredFlag, viper.GetBool)
+		maxTokens := setting(cmd, "chunk-tokens", chunkTokens, viper.GetInt)
+		cache := setting(cmd, "cache-prompt", cachePrompt, viper.GetBool)
+		meaningOpts := resolveMeaning(cmd)
+		if serverURL != "" {
+			backend = llama.NewRemoteServer(serverURL)
+			if len(adapters) > 0 {
+				cmd.PrintErrln(warningStyle.Render("LoRA adapters are ignored with --server-url, they are loaded when nomodit starts llama-server"))
+				adapters = nil
 			}
-			prompt := args[0]
-			server, err := llama.StartServer(LLM, "8091")
+		}
+		if interactive && serverURL == "" {
+			// a TUI session outlives crashes: restart llama-server instead of quitting
+			backend = llama.NewSupervisor(func() *llama.Server {
+				return llama.NewServerWithOptions(LLM, Port, launchOpts)
+			}, llama.SupervisorOptions{
+				MaxRestarts:    viper.GetInt("max_restarts"),
+				RetryInference: viper.GetBool("retry_inference"),
+			})
+		}
+		// fill llama-server's slots (--parallel) without queueing past them
+		scheduler := llama.NewScheduler(backend, 0)
+		backend = scheduler
+
+		if interactive {
+			emb, err := startEmbedder(launchOpts, meaningOpts)
 			if err != nil {
-				cmd.PrintErrln(dangerStyle.Render(err.Error()))
-				return
+				return err
 			}
-			defer server.Stop()
+			defer emb.Stop()
+			opts := tui.Options{
+				Instruction: Instruction,
+				Sampling:    sampling,
+				Structured:  structured,
+				Mode:        mode,
+				ChunkTokens: maxTokens,
+				CachePrompt: cache,
+				LoRA:        adapters,
 
-			inferenceReq := llama.InferenceReq{
-				Prompt: prompt,
-				Temp:   0.3,
+				MinSimilarity:       meaningOpts.minSimilarity,
+				RejectMeaningChange: meaningOpts.reject,
 			}
-			respStream, err := server.Inference(inferenceReq)
-			if err != nil {
-				cmd.PrintErrln(dangerStyle.Render(err.Error()))
-				return
+			if emb != nil {
+				opts.Embedder = emb
 			}
+			return tui.Launch(ctx, backend, opts)
+		}
 
-			for resp := range respStream {
-				fmt.Print(resp.Content)
+		if err := backend.Start(); err != nil {
+			return err
+		}
+		defer backend.Stop()
+		if Verbose && scheduler.CommandLine() != "" {
+			cmd.PrintErrln("Launched " + scheduler.CommandLine())
+		}
+		emb, err := startEmbedder(launchOpts, meaningOpts)
+		if err != nil {
+			return err
+		}
+		defer emb.Stop()
+
+		if err := waitReady(ctx, cmd, backend); err != nil {
+			return err
+		}
+
+		out, err := textio.NewOutput(outPath)
+		if err != nil {
+			return err
+		}
+		defer out.Discard()
+		editor := &cliEditor{
+			cmd:     cmd,
+			backend: backend,
+			spec: prompt.Spec{
+				Instruction: Instruction,
+				Mode:        mode,
+				Structured:  structured,
+				Sampling:    sampling,
+				CachePrompt: cache,
+				LoRA:        prompt.Adapter(Instruction, adapters),
+			},
+			chunkTokens: maxTokens,
+			emb:         emb,
+			out:         out,
+		}
+		if Verbose && editor.spec.LoRA != "" {
+			cmd.PrintErrln("Using the " + editor.spec.LoRA + " LoRA adapter")
+		}
+		editCtx := ctx
+		if len(inputs) > 1 {
+			// a list of texts is batch work, see llama.PriorityBatch
+			editCtx = llama.WithPriority(ctx, llama.PriorityBatch)
+		}
+		for _, in := range inputs {
+			if len(inputs) > 1 && in.Name != "" {
+				cmd.PrintErrln(reasoningStyle.Render("Editing " + in.Name))
+			}
+			if err := editor.edit(editCtx, in.Text); err != nil {
+				return err
 			}
-			fmt.Println("\n\n*Inference completed*")
 		}
+		return out.Commit()
 	},
 }
 
+// textArgs accepts free text to edit, but not a first argument that looks
+// like a mistyped subcommand, e.g. "nomodit modles list". Such a word can
+// still be edited after -- or from stdin.
+func textArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 || cmd.ArgsLenAtDash() == 0 || strings.ContainsFunc(args[0], unicode.IsSpace) {
+		return nil
+	}
+	if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+		return fmt.Errorf("unknown command %q, did you mean %s?\nTo edit the text %q, pass it after -- or on stdin", args[0], strings.Join(suggestions, " or "), args[0])
+	}
+	return nil
+}
+
+// modelSetting returns the flag's value if it was given on the command line,
+// otherwise the config value of the same name for the current LLM.
+func modelSetting(cmd *cobra.Command, name, flagValue string) string {
+	if cmd.Flags().Changed(name) {
+		return flagValue
+	}
+	return config.ModelString(LLM, name)
+}
+
+// setting returns the flag's value if it was given on the command line or
+// isn't in the config, otherwise the config value of the same name, read with
+// get. Unlike flags bound to viper, such flags are never saved to the config
+// file, and their defaults aren't either.
+func setting[T any](cmd *cobra.Command, name string, flagValue T, get func(key string) T) T {
+	key := strings.ReplaceAll(name, "-", "_")
+	if cmd.Flags().Changed(name) || !viper.IsSet(key) {
+		return flagValue
+	}
+	return get(key)
+}
+
+// waitReady blocks until the backend has finished starting up, echoing its
+// status messages to stderr. Download progress redraws a single line.
+func waitReady(ctx context.Context, cmd *cobra.Command, backend llama.Backend) error {
+	progressShown := false
+	for status := range backend.StatusUpdates(ctx) {
+		if status.Progress != nil {
+			cmd.PrintErrf("\r%s %s\x1b[K", status.Progress.Bar(30), status.Progress)
+			progressShown = true
+			continue
+		}
+		if progressShown {
+			cmd.PrintErrln()
+			progressShown = false
+		}
+		if status.IsError {
+			return errors.New(status.Message)
+		}
+		cmd.PrintErrln(status.Message)
+	}
+	if progressShown {
+		cmd.PrintErrln()
+	}
+	return ctx.Err()
+}
+
+// stdinPiped reports whether stdin is a pipe or file rather than a terminal.
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -83,8 +284,23 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&LLM, "llm", "m", "unsloth/gemma-3-1b-it-GGUF", "LLM to be used")
+	rootCmd.Flags().StringVarP(&LLM, "llm", "m", "unsloth/gemma-3-1b-it-GGUF", "LLM to be used: Hugging Face repo, repo:quant (e.g. unsloth/gemma-3-1b-it-GGUF:Q8_0) or path to a local .gguf")
+	rootCmd.Flags().StringVar(&Port, "port", "", "Port for llama-server (default: pick a free port)")
+	rootCmd.Flags().StringVar(&ServerURL, "server-url", "", "Use an already running llama-server at this URL instead of starting one")
 	rootCmd.Flags().StringVarP(&Instruction, "instruction", "i", "Fix grammar and improve clarity of this text", "Instructions to use for the LLM")
 
+	rootCmd.Flags().StringVar(&Mode, "mode", "", "Request format: auto, chat (model's chat template) or completion (raw prompt) (default \"auto\")")
+	rootCmd.Flags().BoolVarP(&Verbose, "verbose", "v", false, "Print the llama-server command line and generation metrics (tokens/s, time to first token, token counts) to stderr")
+	rootCmd.Flags().StringArrayVar(&inputFiles, "file", nil, "Edit this file, or the files matching this glob (repeatable)")
+	rootCmd.Flags().StringVarP(&outPath, "out", "o", "", "Write the edited text to this file instead of stdout (only replaced once every edit succeeded)")
+	rootCmd.Flags().BoolVar(&structuredFlag, "structured", false, "Ask the model for JSON listing every edit with its category and explanation")
+	rootCmd.Flags().IntVar(&chunkTokens, "chunk-tokens", chunk.DefaultMaxTokens, "Edit texts longer than this many tokens paragraph by paragraph (capped by what fits the context)")
+	rootCmd.Flags().BoolVar(&cachePrompt, "cache-prompt", true, "Let llama-server reuse the instruction's part of the prompt from its cache (--cache-prompt=false for outputs that don't depend on earlier requests)")
+	addSamplingFlags(rootCmd)
+	addLaunchFlags(rootCmd)
+	addMeaningFlags(rootCmd)
+
 	viper.BindPFlag("llm", rootCmd.Flags().Lookup("llm"))
+	viper.SetDefault("retry_inference", true)
+	viper.SetDefault("max_restarts", llama.DefaultMaxRestarts)
 }
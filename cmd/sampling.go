@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Muzz Khan muzxmmilkhxn@gmail.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/muzzlol/nomodit/pkg/config"
+	"github.com/muzzlol/nomodit/pkg/llama"
+	"github.com/spf13/cobra"
+)
+
+var (
+	Profile string
+	// samplingFlags holds the values of the sampling flags, which only
+	// override the profile if given.
+	samplingFlags struct {
+		Temp, TopP, MinP, TypicalP, RepeatPenalty, PresencePenalty float32
+		TopK, Seed, NKeep                                          int
+		Stop                                                       []string
+	}
+)
+
+// samplingOverrides maps each sampling flag to the field it overrides.
+var samplingOverrides = map[string]func(dst *llama.Sampling){
+	"temp":             func(dst *llama.Sampling) { dst.Temp = llama.Float(samplingFlags.Temp) },
+	"top-k":            func(dst *llama.Sampling) { dst.TopK = llama.Int(samplingFlags.TopK) },
+	"top-p":            func(dst *llama.Sampling) { dst.TopP = llama.Float(samplingFlags.TopP) },
+	"min-p":            func(dst *llama.Sampling) { dst.MinP = llama.Float(samplingFlags.MinP) },
+	"typical-p":        func(dst *llama.Sampling) { dst.TypicalP = llama.Float(samplingFlags.TypicalP) },
+	"repeat-penalty":   func(dst *llama.Sampling) { dst.RepeatPenalty = llama.Float(samplingFlags.RepeatPenalty) },
+	"presence-penalty": func(dst *llama.Sampling) { dst.PresencePenalty = llama.Float(samplingFlags.PresencePenalty) },
+	"seed":             func(dst *llama.Sampling) { dst.Seed = llama.Int(samplingFlags.Seed) },
+	"stop":             func(dst *llama.Sampling) { dst.Stop = samplingFlags.Stop },
+	"n-keep":           func(dst *llama.Sampling) { dst.NKeep = llama.Int(samplingFlags.NKeep) },
+}
+
+// resolveSampling layers the sampling flags given on the command line over the
+// configured profile.
+func resolveSampling(cmd *cobra.Command) (llama.Sampling, error) {
+	s, err := config.Sampling(Profile)
+	if err != nil {
+		return s, err
+	}
+	for name, override := range samplingOverrides {
+		if cmd.Flags().Changed(name) {
+			override(&s)
+		}
+	}
+	return s, nil
+}
+
+func addSamplingFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVarP(&Profile, "profile", "p", "", fmt.Sprintf("Sampling profile: %s (default %q)", strings.Join(config.ProfileNames(), ", "), config.DefaultProfile))
+	flags.Float32Var(&samplingFlags.Temp, "temp", 0, "Sampling temperature, 0 for greedy decoding")
+	flags.IntVar(&samplingFlags.TopK, "top-k", 0, "Top-k sampling")
+	flags.Float32Var(&samplingFlags.TopP, "top-p", 0, "Top-p (nucleus) sampling")
+	flags.Float32Var(&samplingFlags.MinP, "min-p", 0, "Min-p sampling")
+	flags.Float32Var(&samplingFlags.TypicalP, "typical-p", 0, "Locally typical sampling")
+	flags.Float32Var(&samplingFlags.RepeatPenalty, "repeat-penalty", 0, "Penalty for repeated tokens")
+	flags.Float32Var(&samplingFlags.PresencePenalty, "presence-penalty", 0, "Penalty for tokens already present")
+	flags.IntVar(&samplingFlags.Seed, "seed", 0, "RNG seed (default: random)")
+	flags.StringArrayVar(&samplingFlags.Stop, "stop", nil, "Stop sequence, can be repeated")
+	flags.IntVar(&samplingFlags.NKeep, "n-keep", 0, "Prompt tokens to keep when the context overflows")
+}
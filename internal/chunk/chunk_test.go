@@ -0,0 +1,185 @@
+package chunk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/muzzlol/nomodit/internal/prompt"
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+// shouter is a backend whose tokens are words and whose edit upper-cases the
+// text, padded with the stray whitespace models like to add.
+type shouter struct {
+	nCtx int
+
+	mu         sync.Mutex
+	prompts    []string
+	priorities []llama.Priority
+}
+
+func (s *shouter) Tokenize(_ context.Context, text string) ([]int, error) {
+	return make([]int, len(strings.Fields(text))), nil
+}
+
+func (s *shouter) Detokenize(context.Context, []int) (string, error) { return "", nil }
+
+func (s *shouter) ContextSize(context.Context) (int, error) { return s.nCtx, nil }
+
+func (s *shouter) Start() error                                            { return nil }
+func (s *shouter) StatusUpdates(context.Context) <-chan llama.ServerStatus { return nil }
+func (s *shouter) Stop()                                                   {}
+
+func (s *shouter) Chat(context.Context, llama.ChatReq) (<-chan llama.InferenceResp, error) {
+	return nil, errors.New("chat not supported")
+}
+
+var promptText = regexp.MustCompile(`(?s)Text(?: to fix)?: "(.*)"\n$`)
+
+func (s *shouter) Inference(ctx context.Context, req llama.InferenceReq) (<-chan llama.InferenceResp, error) {
+	s.mu.Lock()
+	s.prompts = append(s.prompts, req.Prompt)
+	s.priorities = append(s.priorities, llama.PriorityOf(ctx))
+	s.mu.Unlock()
+	text := strings.ToUpper(promptText.FindStringSubmatch(req.Prompt)[1])
+	answer := "\n " + text + " \n"
+	if req.JSONSchema != nil {
+		b, _ := json.Marshal(llama.EditResult{
+			EditedText: answer,
+			Edits:      []llama.Edit{{Original: text, Replacement: text, Category: "other"}},
+		})
+		answer = string(b)
+	}
+	out := make(chan llama.InferenceResp, 3)
+	half := len(answer) / 2
+	out <- llama.InferenceResp{Content: answer[:half]}
+	out <- llama.InferenceResp{Content: answer[half:]}
+	out <- llama.InferenceResp{Stop: true, Result: &llama.Result{TokensPredicted: 2, Timings: llama.Timings{PredictedN: 2, PredictedMS: 100}}}
+	close(out)
+	return out, nil
+}
+
+func TestSplit(t *testing.T) {
+	ctx := context.Background()
+	tok := &shouter{}
+	text := "  One two three. Four five six. Seven eight.\n\n\nNine ten.\r\n \r\nEleven twelve thirteen fourteen fifteen sixteen.\n"
+
+	doc, err := Split(ctx, tok, text, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Chunks) != 1 || doc.Chunks[0].Text != text {
+		t.Errorf("a text that fits should be one verbatim chunk, got %q", doc.Chunks)
+	}
+
+	doc, err = Split(ctx, tok, text, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.String() != text {
+		t.Errorf("String() = %q, want the original %q", doc.String(), text)
+	}
+	var got []string
+	for _, c := range doc.Chunks {
+		got = append(got, c.Text)
+	}
+	want := []string{
+		"One two three.", "Four five six. Seven eight.", // too long for one, split between sentences
+		"Nine ten.",
+		"Eleven twelve thirteen fourteen fifteen sixteen.", // a single sentence can't be split
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("chunks = %q, want %q", got, want)
+	}
+	if doc.Chunks[0].Sep != "  " || doc.Chunks[1].Sep != " " || doc.Chunks[2].Sep != "\n\n\n" || doc.Tail != "\n" {
+		t.Errorf("separators not kept: %q", doc)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	backend := &shouter{nCtx: 4096}
+	text := "first paragraph here.\n\n  second one.\n"
+	spec := prompt.Spec{Instruction: "Shout", Text: text, Mode: prompt.ModeCompletion}
+
+	job, err := Plan(ctx, backend, spec, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", job.Len())
+	}
+
+	var content strings.Builder
+	var progress []int
+	var result *llama.Result
+	for event := range job.Run(ctx, backend) {
+		if event.Err != nil {
+			t.Fatal(event.Err)
+		}
+		if event.Content == "" && !event.Stop {
+			progress = append(progress, event.Chunk)
+		}
+		content.WriteString(event.Content)
+		result = event.Result
+	}
+	if want := "FIRST PARAGRAPH HERE.\n\n  SECOND ONE.\n"; content.String() != want {
+		t.Errorf("stitched %q, want %q", content.String(), want)
+	}
+	if len(progress) != 2 || progress[1] != 2 {
+		t.Errorf("progress events for chunks %v, want [1 2]", progress)
+	}
+	if result == nil || result.TokensPredicted != 4 || result.Timings.PredictedPerSecond != 20 {
+		t.Errorf("result = %+v, want the chunks added up", result)
+	}
+	// each chunk sees its neighbour
+	prompts := strings.Join(backend.prompts, "\n---\n")
+	if !strings.Contains(prompts, `Following text, for context only (do not edit or repeat it): "second one."`) ||
+		!strings.Contains(prompts, `Preceding text, for context only (do not edit or repeat it): "first paragraph here."`) {
+		t.Errorf("prompts lack context:\n%s", prompts)
+	}
+	for _, p := range backend.priorities {
+		if p != llama.PriorityBatch {
+			t.Errorf("chunks sent with priority %v, want them all batch", backend.priorities)
+			break
+		}
+	}
+}
+
+func TestRunStructured(t *testing.T) {
+	ctx := context.Background()
+	backend := &shouter{nCtx: 4096}
+	spec := prompt.Spec{Instruction: "Shout", Text: "a \"quoted\" line.\n\nb.", Mode: prompt.ModeCompletion, Structured: true}
+	job, err := Plan(ctx, backend, spec, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d llama.EditDecoder
+	for event := range job.Run(ctx, backend) {
+		if event.Err != nil {
+			t.Fatal(event.Err)
+		}
+		d.Write(event.Content)
+	}
+	result, err := d.Result()
+	if err != nil {
+		t.Fatalf("%v in %s", err, d.Raw())
+	}
+	if result.EditedText != "A \"QUOTED\" LINE.\n\nB." || len(result.Edits) != 2 {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestPlanTooLong(t *testing.T) {
+	backend := &shouter{nCtx: 300}
+	spec := prompt.Spec{Instruction: "Shout", Text: strings.Repeat("word ", 500), Mode: prompt.ModeCompletion}
+	if _, err := Plan(context.Background(), backend, spec, 0); !errors.Is(err, prompt.ErrInputTooLong) {
+		t.Errorf("Plan error = %v, want ErrInputTooLong", err)
+	}
+}
@@ -0,0 +1,319 @@
+package chunk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/muzzlol/nomodit/internal/prompt"
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+// Event is one update of a Job: an event of the model's answer, tagged with
+// the chunk it belongs to.
+//
+// A single chunk is streamed as is. With several, each chunk's answer
+// (reasoning included) is collected and sent in one event, preceded by an
+// empty event when the output reaches it, so Content concatenates to the
+// stitched document. A structured Job produces one llama.EditResult for the
+// whole document, streamed as JSON in the same way.
+type Event struct {
+	llama.InferenceResp
+	Chunk  int // from 1
+	Chunks int
+}
+
+// Job is an edit split into requests that each fit the model's context.
+type Job struct {
+	Doc     Document
+	specs   []prompt.Spec
+	budgets []prompt.Budget
+}
+
+// Single returns a Job sending spec as is, for backends that can't measure
+// the text.
+func Single(spec prompt.Spec) *Job {
+	return &Job{
+		Doc:   Document{Chunks: []Chunk{{Text: spec.Text}}},
+		specs: []prompt.Spec{spec},
+	}
+}
+
+// Plan splits spec's text into chunks of at most maxTokens (or whatever is
+// less and fits the context) and sizes the request of each with prompt.Plan.
+// It fails with prompt.ErrInputTooLong if a chunk can't fit, which only
+// happens for single sentences longer than the context.
+func Plan(ctx context.Context, tok llama.Tokenizer, spec prompt.Spec, maxTokens int) (*Job, error) {
+	nCtx, err := tok.ContextSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	size := fitTokens(nCtx, spec.Structured, prompt.Reasons(ctx, tok))
+	if maxTokens > 0 {
+		size = min(size, maxTokens)
+	}
+	doc, err := Split(ctx, tok, spec.Text, size)
+	if err != nil {
+		return nil, fmt.Errorf("counting tokens: %w", err)
+	}
+
+	job := &Job{Doc: doc}
+	for i, c := range doc.Chunks {
+		s := spec
+		s.Text = c.Text
+		if i > 0 {
+			s.Before = trailing(doc.Chunks[i-1].Text)
+		}
+		if i < len(doc.Chunks)-1 {
+			s.After = leading(doc.Chunks[i+1].Text)
+		}
+		budget, err := prompt.Plan(ctx, tok, s)
+		if err != nil {
+			if len(doc.Chunks) > 1 {
+				err = fmt.Errorf("part %d of %d: %w", i+1, len(doc.Chunks), err)
+			}
+			return nil, err
+		}
+		s.NPredict = budget.NPredict
+		job.specs = append(job.specs, s)
+		job.budgets = append(job.budgets, budget)
+	}
+	return job, nil
+}
+
+// fitTokens is the largest text that leaves room for its answer, and its
+// reasoning if the model reasons, in a context of nCtx tokens, see
+// prompt.Plan, with some to spare for the instruction and the neighbouring
+// text.
+func fitTokens(nCtx int, structured, reasoning bool) int {
+	if reasoning {
+		nCtx -= prompt.ReasoningTokens
+	}
+	if structured {
+		// text + 3n+128 answer
+		return max((nCtx-384)/4, 64)
+	}
+	// text + 1.25n+32 answer
+	return max((nCtx-256)*4/9, 64)
+}
+
+// Len returns the number of chunks.
+func (j *Job) Len() int {
+	return len(j.specs)
+}
+
+// Tight reports whether any chunk got less room for its answer than it
+// usually needs.
+func (j *Job) Tight() bool {
+	for _, b := range j.budgets {
+		if b.Tight {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the token budget, if the Job was planned.
+func (j *Job) String() string {
+	switch {
+	case len(j.budgets) == 0:
+		return "token budget unknown"
+	case len(j.budgets) == 1:
+		return j.budgets[0].String()
+	}
+	largest := j.budgets[0]
+	for _, b := range j.budgets[1:] {
+		if b.Text > largest.Text {
+			largest = b
+		}
+	}
+	return fmt.Sprintf("%d parts, largest: %s", len(j.budgets), largest)
+}
+
+// Run sends the chunks and streams the result. The channel is closed after
+// the final (Stop) event, or an event with Err set if a chunk failed.
+func (j *Job) Run(ctx context.Context, backend llama.Backend) <-chan Event {
+	out := make(chan Event, 100)
+	go func() {
+		defer close(out)
+		if len(j.specs) == 1 {
+			j.stream(ctx, backend, out)
+			return
+		}
+		j.runChunks(ctx, backend, out)
+	}()
+	return out
+}
+
+// stream forwards the events of a single chunk.
+func (j *Job) stream(ctx context.Context, backend llama.Backend, out chan<- Event) {
+	events, err := prompt.Send(ctx, backend, j.specs[0])
+	if err != nil {
+		send(ctx, out, Event{InferenceResp: llama.InferenceResp{Err: err}, Chunk: 1, Chunks: 1})
+		return
+	}
+	for event := range events {
+		if !send(ctx, out, Event{InferenceResp: event, Chunk: 1, Chunks: 1}) {
+			return
+		}
+	}
+}
+
+// runChunks sends every chunk at once, leaving it to the backend (see
+// llama.Scheduler) to run as many at a time as it can, and streams the
+// answers back in order. The chunks are batch work, so an interactive request
+// made meanwhile goes ahead of those still queued.
+func (j *Job) runChunks(ctx context.Context, backend llama.Backend, out chan<- Event) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // a failed chunk abandons the rest
+
+	n := len(j.specs)
+	answers := make([]chan answer, n)
+	batch := llama.WithPriority(ctx, llama.PriorityBatch)
+	for i, spec := range j.specs {
+		answers[i] = make(chan answer, 1)
+		go func() {
+			answers[i] <- collect(batch, backend, spec)
+		}()
+	}
+
+	structured := j.specs[0].Structured
+	var total *llama.Result
+	edits := []llama.Edit{}
+	for i := range j.specs {
+		event := Event{Chunk: i + 1, Chunks: n}
+		if !send(ctx, out, event) {
+			return
+		}
+		var a answer
+		select {
+		case a = <-answers[i]:
+		case <-ctx.Done():
+			return
+		}
+		if a.err == nil && structured {
+			var r llama.EditResult
+			if r, a.err = decodeEdit(a.text); a.err == nil {
+				a.text = r.EditedText
+				edits = append(edits, r.Edits...)
+			}
+		}
+		if a.err != nil {
+			event.Err = fmt.Errorf("part %d of %d: %w", i+1, n, a.err)
+			send(ctx, out, event)
+			return
+		}
+		total = addResult(total, a.result)
+
+		event.Reasoning = a.reasoning
+		event.Probs = a.probs
+		event.Content = j.Doc.Chunks[i].Sep + strings.TrimSpace(a.text)
+		if structured {
+			event.Content = jsonStringBody(event.Content)
+			if i == 0 {
+				event.Content = `{"edited_text":"` + event.Content
+			}
+		}
+		if !send(ctx, out, event) {
+			return
+		}
+	}
+
+	final := Event{InferenceResp: llama.InferenceResp{Content: j.Doc.Tail, Stop: true, Result: total}, Chunk: n, Chunks: n}
+	if structured {
+		list, _ := json.Marshal(edits)
+		final.Content = jsonStringBody(j.Doc.Tail) + `","edits":` + string(list) + "}"
+	}
+	send(ctx, out, final)
+}
+
+// answer is the model's whole reply for one chunk.
+type answer struct {
+	text, reasoning string
+	probs           []llama.TokenProb
+	result          *llama.Result
+	err             error
+}
+
+// collect waits for the whole answer to spec. A stream the supervisor
+// re-sent after a crash starts over.
+func collect(ctx context.Context, backend llama.Backend, spec prompt.Spec) answer {
+	events, err := prompt.Send(ctx, backend, spec)
+	if err != nil {
+		return answer{err: err}
+	}
+	var text, reasoning strings.Builder
+	var probs []llama.TokenProb
+	for event := range events {
+		if event.Err != nil {
+			return answer{err: event.Err}
+		}
+		if event.Retry {
+			text.Reset()
+			reasoning.Reset()
+			probs = nil
+		}
+		text.WriteString(event.Content)
+		reasoning.WriteString(event.Reasoning)
+		probs = append(probs, event.Probs...)
+		if event.Stop {
+			return answer{text: text.String(), reasoning: reasoning.String(), probs: probs, result: event.Result}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return answer{err: err}
+	}
+	return answer{err: llama.ErrIncompleteStream}
+}
+
+func decodeEdit(raw string) (llama.EditResult, error) {
+	var d llama.EditDecoder
+	d.Write(raw)
+	return d.Result()
+}
+
+// jsonStringBody returns s encoded as the inside of a JSON string.
+func jsonStringBody(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// addResult adds the token counts and timings of r to total.
+func addResult(total, r *llama.Result) *llama.Result {
+	if r == nil {
+		return total
+	}
+	if total == nil {
+		sum := *r
+		return &sum
+	}
+	total.TokensPredicted += r.TokensPredicted
+	total.TokensEvaluated += r.TokensEvaluated
+	total.Truncated = total.Truncated || r.Truncated
+	if r.StopType == "limit" {
+		total.StopType = r.StopType
+	}
+	t := &total.Timings
+	t.CacheN += r.Timings.CacheN
+	t.PromptN += r.Timings.PromptN
+	t.PromptMS += r.Timings.PromptMS
+	t.PredictedN += r.Timings.PredictedN
+	t.PredictedMS += r.Timings.PredictedMS
+	if t.PromptMS > 0 {
+		t.PromptPerSecond = float64(t.PromptN) / t.PromptMS * 1000
+	}
+	if t.PredictedMS > 0 {
+		t.PredictedPerSecond = float64(t.PredictedN) / t.PredictedMS * 1000
+	}
+	return total
+}
+
+func send(ctx context.Context, out chan<- Event, event Event) bool {
+	select {
+	case out <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
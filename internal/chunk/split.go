@@ -0,0 +1,170 @@
+// Package chunk edits documents too long for a single request piece by piece:
+// paragraph by paragraph, breaking long paragraphs between sentences, with a
+// little of the neighbouring text sent along for context. The edited pieces
+// are stitched back together with the document's original whitespace.
+package chunk
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+// DefaultMaxTokens caps the size of a chunk even when the context window
+// would fit more, as small models edit long passages poorly.
+const DefaultMaxTokens = 512
+
+var (
+	// one or more blank lines
+	paragraphBreak = regexp.MustCompile(`\n[ \t\r]*\n\s*`)
+	// end of a sentence: punctuation, closing quotes or brackets, then space
+	sentenceEnd = regexp.MustCompile(`[.!?…]+["'”’)\]]*\s+`)
+)
+
+// Chunk is a piece of a document sent to the model on its own.
+type Chunk struct {
+	Sep  string // whitespace before Text, copied to the output as is
+	Text string
+}
+
+// Document is text split into chunks. Concatenating each chunk's Sep and
+// Text, then Tail, gives back the original.
+type Document struct {
+	Chunks []Chunk
+	Tail   string // whitespace after the last chunk
+}
+
+// String reassembles the original text.
+func (d Document) String() string {
+	var b strings.Builder
+	for _, c := range d.Chunks {
+		b.WriteString(c.Sep)
+		b.WriteString(c.Text)
+	}
+	b.WriteString(d.Tail)
+	return b.String()
+}
+
+// Split breaks text into chunks of at most maxTokens tokens: a text that
+// fits is a single chunk kept verbatim, otherwise every paragraph is a chunk,
+// and paragraphs that are still too long are cut between sentences. A single
+// sentence longer than maxTokens becomes a chunk of its own.
+func Split(ctx context.Context, tok llama.Tokenizer, text string, maxTokens int) (Document, error) {
+	n, err := countTokens(ctx, tok, text)
+	if err != nil {
+		return Document{}, err
+	}
+	if n <= maxTokens {
+		return Document{Chunks: []Chunk{{Text: text}}}, nil
+	}
+
+	var doc Document
+	sep, last := "", 0
+	breaks := append(paragraphBreak.FindAllStringIndex(text, -1), []int{len(text), len(text)})
+	for _, br := range breaks {
+		para := text[last:br[0]]
+		body := strings.TrimLeftFunc(para, unicode.IsSpace)
+		sep += para[:len(para)-len(body)]
+		after := body[len(strings.TrimRightFunc(body, unicode.IsSpace)):]
+		body = body[:len(body)-len(after)]
+		if body != "" {
+			chunks, err := splitParagraph(ctx, tok, body, maxTokens)
+			if err != nil {
+				return Document{}, err
+			}
+			chunks[0].Sep = sep
+			doc.Chunks = append(doc.Chunks, chunks...)
+			sep = ""
+		}
+		sep += after + text[br[0]:br[1]]
+		last = br[1]
+	}
+	doc.Tail = sep
+	return doc, nil
+}
+
+// splitParagraph packs the sentences of para into as few chunks as fit in
+// maxTokens, in order. The first chunk has no Sep.
+func splitParagraph(ctx context.Context, tok llama.Tokenizer, para string, maxTokens int) ([]Chunk, error) {
+	n, err := countTokens(ctx, tok, para)
+	if err != nil {
+		return nil, err
+	}
+	if n <= maxTokens {
+		return []Chunk{{Text: para}}, nil
+	}
+
+	// sentence i is para[starts[i]:ends[i]], followed by whitespace up to starts[i+1]
+	starts, ends := []int{0}, []int{}
+	for _, m := range sentenceEnd.FindAllStringIndex(para, -1) {
+		if m[1] == len(para) {
+			break
+		}
+		end := m[0] + len(strings.TrimRightFunc(para[m[0]:m[1]], unicode.IsSpace))
+		ends = append(ends, end)
+		starts = append(starts, m[1])
+	}
+	ends = append(ends, len(para))
+
+	var chunks []Chunk
+	first, size := 0, 0 // sentence the current chunk starts at, and its tokens
+	flush := func(upTo int) {
+		c := Chunk{Text: para[starts[first]:ends[upTo]]}
+		if first > 0 {
+			c.Sep = para[ends[first-1]:starts[first]]
+		}
+		chunks = append(chunks, c)
+	}
+	for i := range starts {
+		n, err := countTokens(ctx, tok, para[starts[i]:ends[i]])
+		if err != nil {
+			return nil, err
+		}
+		if i > first && size+n > maxTokens {
+			flush(i - 1)
+			first, size = i, 0
+		}
+		size += n
+	}
+	flush(len(starts) - 1)
+	return chunks, nil
+}
+
+func countTokens(ctx context.Context, tok llama.Tokenizer, text string) (int, error) {
+	tokens, err := tok.Tokenize(ctx, text)
+	return len(tokens), err
+}
+
+// contextRunes is about how much of the neighbouring chunks is shown with
+// each chunk.
+const contextRunes = 200
+
+// leading returns the start of text, cut at a word boundary after about
+// contextRunes characters.
+func leading(text string) string {
+	r := []rune(text)
+	if len(r) <= contextRunes {
+		return text
+	}
+	s := string(r[:contextRunes])
+	if i := strings.LastIndexFunc(s, unicode.IsSpace); i > 0 {
+		s = s[:i]
+	}
+	return s + "…"
+}
+
+// trailing returns the end of text, cut at a word boundary.
+func trailing(text string) string {
+	r := []rune(text)
+	if len(r) <= contextRunes {
+		return text
+	}
+	s := string(r[len(r)-contextRunes:])
+	if i := strings.IndexFunc(s, unicode.IsSpace); i >= 0 {
+		s = strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+	}
+	return "…" + s
+}
@@ -0,0 +1,256 @@
+// Package logs keeps nomodit's and llama-server's logs in size-rotated files
+// under the user's state directory, so backend failures can be looked into
+// after the session that hit them is gone.
+package logs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Log file names inside Dir.
+const (
+	NomoditLog = "nomodit.log"
+	ServerLog  = "llama-server.log"
+)
+
+// Rotation limits, variables so tests can shrink them.
+var (
+	maxSize    int64 = 10 << 20 // bytes per file before it is rotated
+	maxBackups       = 3        // rotated files kept, name.1 being the newest
+)
+
+// followPollInterval is how often Follow checks for new lines.
+var followPollInterval = 500 * time.Millisecond
+
+// Dir returns the directory logs are written to: $XDG_STATE_HOME/nomodit,
+// falling back to ~/.local/state/nomodit, or the platform's usual place for
+// logs on macOS and Windows.
+func Dir() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Logs", "nomodit"), nil
+	case "windows":
+		base := os.Getenv("LOCALAPPDATA")
+		if base == "" {
+			return "", fmt.Errorf("LOCALAPPDATA environment variable not set")
+		}
+		return filepath.Join(base, "nomodit", "logs"), nil
+	default: // assuming linux-like
+		if stateHome := os.Getenv("XDG_STATE_HOME"); stateHome != "" {
+			return filepath.Join(stateHome, "nomodit"), nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".local", "state", "nomodit"), nil
+	}
+}
+
+// Path returns the full path of the log file name.
+func Path(name string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// File is an append-only log file that is rotated once it grows past
+// maxSize: name becomes name.1, name.1 becomes name.2 and so on, dropping
+// the oldest. It is safe for concurrent use.
+type File struct {
+	path string
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens (creating Dir if needed) the log file name for appending.
+func Open(name string) (*File, error) {
+	path, err := Path(name)
+	if err != nil {
+		return nil, err
+	}
+	return openFile(path)
+}
+
+func openFile(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	l := &File{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *File) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past maxSize.
+func (l *File) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+	if l.size > 0 && l.size+int64(len(p)) > maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *File) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(backup(l.path, maxBackups))
+	for i := maxBackups - 1; i >= 1; i-- {
+		os.Rename(backup(l.path, i), backup(l.path, i+1))
+	}
+	if err := os.Rename(l.path, backup(l.path, 1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func backup(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
+
+// Close closes the file. Later writes fail with os.ErrClosed.
+func (l *File) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Setup sends the standard logger to the rotating nomodit.log.
+func Setup() (*File, error) {
+	f, err := Open(NomoditLog)
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(f)
+	log.Println("--- Log Start ---")
+	return f, nil
+}
+
+// Tail returns the last n lines of the log file at path, reaching into its
+// rotated backups if the current file is shorter.
+func Tail(path string, n int) ([]string, error) {
+	var lines []string
+	for i := 0; i <= maxBackups && len(lines) < n; i++ {
+		p := path
+		if i > 0 {
+			p = backup(path, i)
+		}
+		fileLines, err := readLines(p)
+		if os.IsNotExist(err) {
+			if i == 0 {
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(fileLines, lines...)
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// Follow copies what is appended to the log file at path to w until ctx is
+// cancelled, picking up the new file after a rotation.
+func Follow(ctx context.Context, path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+		// after a rotation f is the first backup; switch to the new file
+		current, err := os.Stat(path)
+		if err != nil {
+			continue // not recreated yet
+		}
+		if opened, err := f.Stat(); err == nil && !os.SameFile(opened, current) {
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			io.Copy(w, f) // whatever was written just before the rotation
+			f.Close()
+			f = next
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDir(t *testing.T) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("XDG_STATE_HOME only applies to linux-like systems")
+	}
+	t.Setenv("XDG_STATE_HOME", "/tmp/state")
+	if dir, err := Dir(); err != nil || dir != "/tmp/state/nomodit" {
+		t.Errorf("Dir() = %q, %v", dir, err)
+	}
+}
+
+func shrinkLimits(t *testing.T, size int64, backups int) {
+	t.Helper()
+	savedSize, savedBackups := maxSize, maxBackups
+	maxSize, maxBackups = size, backups
+	t.Cleanup(func() { maxSize, maxBackups = savedSize, savedBackups })
+}
+
+func TestFileRotation(t *testing.T) {
+	shrinkLimits(t, 20, 2)
+	path := filepath.Join(t.TempDir(), "logs", "test.log")
+	f, err := openFile(path)
+	if err != nil {
+		t.Fatalf("openFile: %v", err)
+	}
+	defer f.Close()
+
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(f, "line %d 123456\n", i) // 14 bytes, so one line per file
+	}
+
+	for name, want := range map[string]string{
+		path:        "line 5 123456\n",
+		path + ".1": "line 4 123456\n",
+		path + ".2": "line 3 123456\n",
+	} {
+		got, err := os.ReadFile(name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", filepath.Base(name), got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more than 2 backups: %v", err)
+	}
+
+	lines, err := Tail(path, 2)
+	if err != nil || strings.Join(lines, "|") != "line 4 123456|line 5 123456" {
+		t.Errorf("Tail(2) = %q, %v", lines, err)
+	}
+	lines, _ = Tail(path, 10)
+	if len(lines) != 3 {
+		t.Errorf("Tail(10) = %q, want the 3 lines still on disk", lines)
+	}
+}
+
+func TestTailMissing(t *testing.T) {
+	if _, err := Tail(filepath.Join(t.TempDir(), "none.log"), 5); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want not exist", err)
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFollow(t *testing.T) {
+	shrinkLimits(t, 30, 1)
+	saved := followPollInterval
+	followPollInterval = 5 * time.Millisecond
+	t.Cleanup(func() { followPollInterval = saved })
+
+	path := filepath.Join(t.TempDir(), "test.log")
+	f, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fmt.Fprintln(f, "old")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var out syncBuffer
+	done := make(chan error)
+	go func() { done <- Follow(ctx, path, &out) }()
+
+	time.Sleep(20 * time.Millisecond)
+	for i := 1; i <= 4; i++ {
+		fmt.Fprintf(f, "follow %d 1234567\n", i) // 18 bytes, rotates every other line
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+	want := "follow 1 1234567\nfollow 2 1234567\nfollow 3 1234567\nfollow 4 1234567\n"
+	if got := out.String(); got != want {
+		t.Errorf("followed %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+// Package meaning checks that an edit keeps the meaning of the text by
+// comparing embeddings of the original and the edited version.
+package meaning
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+// DefaultThreshold is the cosine similarity below which an edit is taken to
+// change the meaning. Grammar fixes typically score above 0.95, paraphrases
+// of the same content above 0.85.
+const DefaultThreshold = 0.85
+
+// Verdict is the outcome of Check.
+type Verdict struct {
+	Similarity float64
+	Threshold  float64
+}
+
+// Changed reports whether the edit scored below the threshold.
+func (v Verdict) Changed() bool {
+	return v.Similarity < v.Threshold
+}
+
+func (v Verdict) String() string {
+	if v.Changed() {
+		return fmt.Sprintf("the edit may change the meaning (similarity %.2f, below %.2f)", v.Similarity, v.Threshold)
+	}
+	return fmt.Sprintf("meaning preserved (similarity %.2f)", v.Similarity)
+}
+
+// Check embeds original and edited and compares them.
+func Check(ctx context.Context, e llama.Embedder, original, edited string, threshold float64) (Verdict, error) {
+	v := Verdict{Similarity: 1, Threshold: threshold}
+	if strings.TrimSpace(original) == strings.TrimSpace(edited) {
+		return v, nil
+	}
+	vectors, err := e.Embed(ctx, original, edited)
+	if err != nil {
+		return v, fmt.Errorf("embedding: %w", err)
+	}
+	v.Similarity = llama.CosineSimilarity(vectors[0], vectors[1])
+	return v, nil
+}
@@ -0,0 +1,35 @@
+package meaning
+
+import (
+	"context"
+	"testing"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestCheck(t *testing.T) {
+	fake := llamatest.NewServer(llamatest.Config{})
+	defer fake.Close()
+	e := llama.NewRemoteServer(fake.URL)
+	ctx := context.Background()
+	original := "The meeting was postponed because of the heavy rain last night."
+
+	tests := []struct {
+		edited  string
+		changed bool
+	}{
+		{original, false},
+		{"The meeting was postponed because of heavy rain last night.", false},
+		{"We should buy a bigger boat before summer comes.", true},
+	}
+	for _, tt := range tests {
+		v, err := Check(ctx, e, original, tt.edited, DefaultThreshold)
+		if err != nil {
+			t.Fatalf("Check(%q): %v", tt.edited, err)
+		}
+		if v.Changed() != tt.changed {
+			t.Errorf("Check(%q) = %v, want changed=%v", tt.edited, v, tt.changed)
+		}
+	}
+}
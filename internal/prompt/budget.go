@@ -0,0 +1,118 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+// ErrInputTooLong is returned by Plan when the text can't be edited within
+// the model's context window.
+var ErrInputTooLong = errors.New("input does not fit the model's context")
+
+// ReasoningTokens is the room set aside for the reasoning of a model that
+// thinks before it answers, on top of the answer.
+const ReasoningTokens = 1024
+
+// chatMessageOverhead is roughly what a chat template adds around each
+// message (role markers, separators), in tokens.
+const chatMessageOverhead = 8
+
+// Budget is how a request fits the model's context window, in tokens.
+type Budget struct {
+	Context  int // n_ctx of the slot serving the request
+	Prompt   int // the whole prompt, including the text
+	Text     int // the text being edited
+	NPredict int // room given to the answer, 0 for no limit
+	// Reasoning is set when the model reasons before answering. llama-server
+	// counts the reasoning against n_predict, so NPredict is left at 0.
+	Reasoning bool
+	// Tight is set when there is less room than an edit of Text usually
+	// needs (with its reasoning), so the answer may come back truncated.
+	Tight bool
+}
+
+func (b Budget) String() string {
+	if b.NPredict == 0 {
+		return fmt.Sprintf("prompt %d tok (text %d) + reasoning and answer of %d context", b.Prompt, b.Text, b.Context)
+	}
+	return fmt.Sprintf("prompt %d tok (text %d) + answer up to %d tok of %d context", b.Prompt, b.Text, b.NPredict, b.Context)
+}
+
+// Plan measures spec with the model's tokenizer and sizes n_predict to the
+// text: a plain edit needs about as many tokens as the text, a structured one
+// repeats it and explains every change. How long a model reasons can't be
+// told, so n_predict is left uncapped for one that does. Plan fails with
+// ErrInputTooLong if not even an answer as long as the text fits.
+func Plan(ctx context.Context, tok llama.Tokenizer, spec Spec) (Budget, error) {
+	var b Budget
+	var err error
+	if b.Context, err = tok.ContextSize(ctx); err != nil {
+		return b, err
+	}
+	if b.Text, err = countTokens(ctx, tok, spec.Text); err != nil {
+		return b, err
+	}
+	if spec.Mode == ModeChat {
+		for _, msg := range Messages(spec) {
+			n, err := countTokens(ctx, tok, msg.Content)
+			if err != nil {
+				return b, err
+			}
+			b.Prompt += n + chatMessageOverhead
+		}
+	} else {
+		if b.Prompt, err = countTokens(ctx, tok, Request(spec).Prompt); err != nil {
+			return b, err
+		}
+	}
+
+	want, least := answerTokens(b.Text, spec.Structured)
+	room := b.Context - b.Prompt
+	if room < least {
+		return b, fmt.Errorf("%w: the prompt takes %d of %d tokens, leaving %d for an answer that needs at least %d; shorten the text or raise --ctx-size",
+			ErrInputTooLong, b.Prompt, b.Context, max(room, 0), least)
+	}
+	if b.Reasoning = Reasons(ctx, tok); b.Reasoning {
+		b.Tight = room < want+ReasoningTokens
+		return b, nil
+	}
+	b.NPredict = min(want, room)
+	b.Tight = b.NPredict < want
+	return b, nil
+}
+
+// Reasons reports whether tok's model reasons before it answers, see
+// llama.Reasoner. Models whose backend can't tell are assumed not to.
+func Reasons(ctx context.Context, tok llama.Tokenizer) bool {
+	r, ok := tok.(llama.Reasoner)
+	if !ok {
+		return false
+	}
+	reasons, err := r.Reasoning(ctx)
+	return err == nil && reasons
+}
+
+// answerTokens estimates how many tokens editing a text of n tokens takes:
+// want leaves slack for the edit growing the text, least is the bare minimum.
+func answerTokens(n int, structured bool) (want, least int) {
+	if structured {
+		// edited_text repeats the text, and every edit quotes and explains a span
+		return 3*n + 128, 2*n + 32
+	}
+	return n + n/4 + 32, n + 8
+}
+
+func countTokens(ctx context.Context, tok llama.Tokenizer, text string) (int, error) {
+	if strings.TrimSpace(text) == "" {
+		return 0, nil
+	}
+	tokens, err := tok.Tokenize(ctx, text)
+	if err != nil {
+		return 0, fmt.Errorf("counting tokens: %w", err)
+	}
+	return len(tokens), nil
+}
@@ -0,0 +1,83 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// wordTokenizer counts one token per word, like llamatest.
+type wordTokenizer struct{ nCtx int }
+
+func (w wordTokenizer) Tokenize(_ context.Context, text string) ([]int, error) {
+	return make([]int, len(strings.Fields(text))), nil
+}
+
+func (w wordTokenizer) Detokenize(context.Context, []int) (string, error) { return "", nil }
+
+func (w wordTokenizer) ContextSize(context.Context) (int, error) { return w.nCtx, nil }
+
+// thinker is a wordTokenizer for a model that reasons before answering.
+type thinker struct{ wordTokenizer }
+
+func (thinker) Reasoning(context.Context) (bool, error) { return true, nil }
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	text := strings.Repeat("word ", 100)
+	spec := Spec{Instruction: "Fix the grammar", Text: text, Mode: ModeCompletion}
+
+	b, err := Plan(ctx, wordTokenizer{4096}, spec)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if b.Text != 100 || b.Prompt <= b.Text || b.Tight {
+		t.Errorf("Plan = %+v", b)
+	}
+	if b.NPredict != 100+25+32 {
+		t.Errorf("NPredict = %d, want %d", b.NPredict, 100+25+32)
+	}
+
+	// a structured answer repeats the text and explains it
+	spec.Structured = true
+	if b, err = Plan(ctx, wordTokenizer{4096}, spec); err != nil || b.NPredict != 3*100+128 {
+		t.Errorf("structured Plan = %+v, %v; want NPredict %d", b, err, 3*100+128)
+	}
+
+	// chat messages count their template overhead
+	spec.Structured, spec.Mode = false, ModeChat
+	chat, err := Plan(ctx, wordTokenizer{4096}, spec)
+	if err != nil || chat.Prompt < b.Text+chatMessageOverhead {
+		t.Errorf("chat Plan = %+v, %v", chat, err)
+	}
+
+	// just enough room for the edit, not the slack
+	spec.Mode = ModeCompletion
+	b, _ = Plan(ctx, wordTokenizer{4096}, spec)
+	tight, err := Plan(ctx, wordTokenizer{b.Prompt + 110}, spec)
+	if err != nil || !tight.Tight || tight.NPredict != 110 {
+		t.Errorf("tight Plan = %+v, %v; want Tight with NPredict 110", tight, err)
+	}
+
+	if _, err := Plan(ctx, wordTokenizer{b.Prompt + 50}, spec); !errors.Is(err, ErrInputTooLong) {
+		t.Errorf("Plan error = %v, want ErrInputTooLong", err)
+	}
+}
+
+func TestPlanReasoning(t *testing.T) {
+	ctx := context.Background()
+	spec := Spec{Instruction: "Fix the grammar", Text: strings.Repeat("word ", 100), Mode: ModeChat}
+
+	b, err := Plan(ctx, thinker{wordTokenizer{4096}}, spec)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if !b.Reasoning || b.NPredict != 0 || b.Tight {
+		t.Errorf("Plan = %+v, want NPredict left uncapped for the reasoning", b)
+	}
+	// room for the answer, but hardly for the reasoning
+	if b, err = Plan(ctx, thinker{wordTokenizer{b.Prompt + 200}}, spec); err != nil || !b.Tight {
+		t.Errorf("Plan = %+v, %v; want Tight", b, err)
+	}
+}
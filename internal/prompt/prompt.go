@@ -0,0 +1,174 @@
+// Package prompt builds the requests nomodit sends to the model for an
+// instruction and a piece of text.
+package prompt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+// Mode selects how requests reach the model.
+type Mode string
+
+const (
+	// ModeCompletion sends a hand-formatted prompt to /completion.
+	ModeCompletion Mode = "completion"
+	// ModeChat sends messages to /v1/chat/completions so the model's own
+	// chat template is applied.
+	ModeChat Mode = "chat"
+	// ModeAuto uses chat for models that look instruction-tuned.
+	ModeAuto Mode = "auto"
+)
+
+// chatModelHints mark model names that ship a chat template.
+var chatModelHints = []string{"-it", "instruct", "chat", "nomodit"}
+
+// ResolveMode turns mode into ModeCompletion or ModeChat for llm.
+func ResolveMode(mode string, llm string) (Mode, error) {
+	switch Mode(mode) {
+	case ModeCompletion, ModeChat:
+		return Mode(mode), nil
+	case ModeAuto, "":
+		name := strings.ToLower(llm)
+		for _, hint := range chatModelHints {
+			if strings.Contains(name, hint) {
+				return ModeChat, nil
+			}
+		}
+		return ModeCompletion, nil
+	}
+	return "", fmt.Errorf("unknown mode %q (want %s, %s or %s)", mode, ModeAuto, ModeChat, ModeCompletion)
+}
+
+// Spec is everything needed to send one edit to the model.
+type Spec struct {
+	Instruction string
+	Text        string
+	Mode        Mode
+	// Structured asks for a llama.EditResult instead of plain text.
+	Structured bool
+	Sampling   llama.Sampling
+	NPredict   int
+	// Before and After are the text around Text when it is one chunk of a
+	// longer document. The model sees them but is told not to edit them.
+	Before, After string
+	// CachePrompt lets llama-server reuse the part of the prompt that only
+	// depends on the instruction, which comes first, from the cache of the
+	// slot that last saw it. Outputs may differ slightly from an uncached
+	// run, as llama.cpp's logits depend on the batch size.
+	CachePrompt bool
+	// Probs asks for the probability of each generated token, see
+	// llama.InferenceResp.Probs.
+	Probs bool
+	// LoRA is the adapter to apply, see Adapter.
+	LoRA string
+}
+
+// affinity groups specs whose prompts share their prefix, see
+// llama.InferenceReq.Affinity.
+func (s Spec) affinity() string {
+	return fmt.Sprintf("%s|%t|%s", s.Mode, s.Structured, s.Instruction)
+}
+
+// Send streams the model's answer for spec from backend.
+func Send(ctx context.Context, backend llama.Backend, spec Spec) (<-chan llama.InferenceResp, error) {
+	if spec.Mode == ModeChat {
+		req := llama.ChatReq{
+			Messages:    Messages(spec),
+			Sampling:    spec.Sampling,
+			MaxTokens:   spec.NPredict,
+			CachePrompt: spec.CachePrompt,
+			Affinity:    spec.affinity(),
+			Logprobs:    spec.Probs,
+			LoRA:        spec.LoRA,
+		}
+		if spec.Structured {
+			req.ResponseFormat = llama.JSONSchemaFormat(llama.EditResultSchema)
+		}
+		return backend.Chat(ctx, req)
+	}
+	req := Request(spec)
+	req.Sampling = spec.Sampling
+	req.NPredict = spec.NPredict
+	req.CachePrompt = spec.CachePrompt
+	req.Affinity = spec.affinity()
+	req.LoRA = spec.LoRA
+	if spec.Probs {
+		req.NProbs = 1 // the chosen token's probability comes with any n_probs
+	}
+	return backend.Inference(ctx, req)
+}
+
+// Build returns a prompt asking for the edited text of spec and nothing else.
+func Build(spec Spec) string {
+	return Prefix(spec) + surroundings(spec) + fmt.Sprintf("Text to fix: \"%s\"\n", spec.Text)
+}
+
+// BuildStructured returns a prompt for a llama.EditResult. The JSON shape
+// itself is enforced by the schema, so this only has to explain the fields.
+func BuildStructured(spec Spec) string {
+	return Prefix(spec) + surroundings(spec) + fmt.Sprintf("Text: \"%s\"\n", spec.Text)
+}
+
+// Prefix returns the start of the completion prompt for spec, which only
+// depends on the instruction, so that every edit with the same instruction
+// can reuse it (see Spec.CachePrompt).
+func Prefix(spec Spec) string {
+	hint := "Respond with ONLY the fixed text, without any additional explanations, comments, or introductory phrases like \"Fixed text:\"."
+	if spec.Structured {
+		hint = structuredHint
+	}
+	return fmt.Sprintf("Instruction: %s\n%s\n\n", spec.Instruction, hint)
+}
+
+// surroundings quotes the text before and after a chunk, one line each.
+func surroundings(spec Spec) string {
+	var b strings.Builder
+	if spec.Before != "" {
+		fmt.Fprintf(&b, "Preceding text, for context only (do not edit or repeat it): \"%s\"\n", spec.Before)
+	}
+	if spec.After != "" {
+		fmt.Fprintf(&b, "Following text, for context only (do not edit or repeat it): \"%s\"\n", spec.After)
+	}
+	return b.String()
+}
+
+const (
+	systemPrompt   = "You are a careful copy editor. You apply the user's instruction to their text and change nothing else."
+	plainHint      = "Reply with the edited text only."
+	structuredHint = "Apply the instruction to the text. Put the full edited text in edited_text, then list every change you made in edits: the original span, its replacement, the category of the change and a one-sentence explanation."
+)
+
+// Messages returns the chat conversation for applying spec's instruction to
+// its text.
+func Messages(spec Spec) []llama.ChatMessage {
+	hint := plainHint
+	if spec.Structured {
+		hint = structuredHint
+	}
+	// the instruction first, so consecutive edits share as much as possible
+	user := spec.Instruction + ":\n\n"
+	if around := surroundings(spec); around != "" {
+		user += around + "\nText to edit:\n\n"
+	}
+	user += spec.Text
+	return []llama.ChatMessage{
+		{Role: "system", Content: systemPrompt + " " + hint},
+		{Role: "user", Content: user},
+	}
+}
+
+// Request returns the completion request for applying spec's instruction to
+// its text. Callers fill in sampling and token limits.
+func Request(spec Spec) llama.InferenceReq {
+	if spec.Structured {
+		return llama.InferenceReq{
+			Prompt:     BuildStructured(spec),
+			JSONSchema: llama.EditResultSchema,
+		}
+	}
+	return llama.InferenceReq{Prompt: Build(spec)}
+}
@@ -0,0 +1,44 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveMode(t *testing.T) {
+	tests := []struct {
+		mode, llm string
+		want      Mode
+	}{
+		{"", "unsloth/gemma-3-1b-it-GGUF", ModeChat},
+		{"auto", "Qwen/Qwen2.5-1.5B-Instruct-GGUF", ModeChat},
+		{"auto", "muzzz/nomodit-4b-GGUF", ModeChat},
+		{"auto", "ggml-org/gpt2-GGUF", ModeCompletion},
+		{"completion", "unsloth/gemma-3-1b-it-GGUF", ModeCompletion},
+		{"chat", "ggml-org/gpt2-GGUF", ModeChat},
+	}
+	for _, tt := range tests {
+		got, err := ResolveMode(tt.mode, tt.llm)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveMode(%q, %q) = %q, %v; want %q", tt.mode, tt.llm, got, err, tt.want)
+		}
+	}
+	if _, err := ResolveMode("fim", "x"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestPrefixComesFirst(t *testing.T) {
+	for _, structured := range []bool{false, true} {
+		a := Spec{Instruction: "Fix grammar", Text: "their going", Structured: structured}
+		b := Spec{Instruction: "Fix grammar", Text: "its fine", Before: "Earlier text.", Structured: structured}
+		pa, pb := Request(a).Prompt, Request(b).Prompt
+		if !strings.HasPrefix(pa, Prefix(a)) || !strings.HasPrefix(pb, Prefix(a)) {
+			t.Errorf("structured=%t: prompts don't start with the shared prefix %q:\n%s\n%s", structured, Prefix(a), pa, pb)
+		}
+		ma, mb := Messages(a), Messages(b)
+		if ma[0] != mb[0] || !strings.HasPrefix(mb[1].Content, "Fix grammar:\n\n") {
+			t.Errorf("structured=%t: messages don't start alike: %q, %q", structured, ma, mb)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package prompt
+
+import (
+	"regexp"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+// tasks are the kinds of edit nomodit recognizes in an instruction, those of
+// the CoEdit dataset the nomodit models are trained on. They are tried in
+// order: "Fix coherence errors" is about coherence, not grammar, and
+// "rewrite" only means paraphrasing if nothing more specific is asked.
+var tasks = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"neutralize", regexp.MustCompile(`(?i)neutral|\bpovs?\b|bias|opinion|points? of view`)},
+	{"formality", regexp.MustCompile(`(?i)formal`)},
+	{"simplify", regexp.MustCompile(`(?i)simpl|less complex|easier to understand`)},
+	{"coherence", regexp.MustCompile(`(?i)coheren|cohesive|logical|consisten|flow|transition`)},
+	{"grammar", regexp.MustCompile(`(?i)grammar|grammatical|fluen|error|mistake`)},
+	{"clarity", regexp.MustCompile(`(?i)clari|clear|readab|understandable|easier to read`)},
+	{"paraphrase", regexp.MustCompile(`(?i)paraphras|reword|rephras|different wording|rewrite`)},
+}
+
+// Task returns the task instruction asks for: grammar, clarity, coherence,
+// simplify, formality, neutralize or paraphrase, or "" if it's none of them.
+func Task(instruction string) string {
+	for _, t := range tasks {
+		if t.pattern.MatchString(instruction) {
+			return t.name
+		}
+	}
+	return ""
+}
+
+// Adapter returns the name of the adapter trained for the task of
+// instruction, if adapters has one named after it, or "" for the bare model.
+func Adapter(instruction string, adapters []llama.LoRA) string {
+	task := Task(instruction)
+	if task == "" {
+		return ""
+	}
+	for _, a := range adapters {
+		if a.Name == task {
+			return a.Name
+		}
+	}
+	return ""
+}
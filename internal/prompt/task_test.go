@@ -0,0 +1,46 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+func TestTask(t *testing.T) {
+	tests := map[string]string{
+		"Fix grammar and improve clarity of this text": "grammar",
+		"Fix disfluencies in the sentence":             "grammar",
+		"Fix coherence errors in this sentence":        "coherence",
+		"Make the text more logical":                   "coherence",
+		"Rewrite this sentence for readability":        "clarity",
+		"Make this easier to read":                     "clarity",
+		"Make this easier to understand":               "simplify",
+		"Rewrite the sentence to be simpler":           "simplify",
+		"Write less informally":                        "formality",
+		"Remove non-neutral POVs":                      "neutralize",
+		"Remove unsourced opinions from this text":     "neutralize",
+		"Rephrase this text":                           "paraphrase",
+		"Rewrite this sentence":                        "paraphrase",
+		"Translate to French":                          "",
+	}
+	for instruction, want := range tests {
+		if got := Task(instruction); got != want {
+			t.Errorf("Task(%q) = %q, want %q", instruction, got, want)
+		}
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	adapters := []llama.LoRA{{Name: "grammar", Path: "gec.gguf"}, {Name: "simplify", Path: "simple.gguf"}}
+	tests := map[string]string{
+		"Fix grammatical errors": "grammar",
+		"Simplify this text":     "simplify",
+		"Paraphrase":             "", // no adapter for it
+		"Translate to French":    "",
+	}
+	for instruction, want := range tests {
+		if got := Adapter(instruction, adapters); got != want {
+			t.Errorf("Adapter(%q) = %q, want %q", instruction, got, want)
+		}
+	}
+}
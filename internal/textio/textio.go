@@ -0,0 +1,175 @@
+// Package textio reads the texts the CLI edits, from arguments, stdin and
+// files, and writes its output to stdout or a file.
+package textio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Input is one text to edit.
+type Input struct {
+	Name string // file path, "stdin", or "" for a command line argument
+	Text string
+}
+
+// Sources are where the texts to edit come from.
+type Sources struct {
+	// Args are texts given on the command line, "-" reading Stdin.
+	Args []string
+	// Files are paths or glob patterns, see filepath.Match.
+	Files []string
+	// Stdin is read when an argument is "-", or when StdinPiped is set and
+	// there are no arguments or files: stdin that isn't a terminal is the
+	// input of a pipeline such as `git log -1 --format=%B | nomodit`.
+	Stdin      io.Reader
+	StdinPiped bool
+}
+
+// Read returns the inputs in the order given, arguments first. A file
+// matched more than once is read once. It is an error for a pattern to match
+// nothing, and for an input to be empty.
+func Read(src Sources) ([]Input, error) {
+	var inputs []Input
+	stdinRead := false
+	readStdin := func() error {
+		if stdinRead {
+			return errors.New("stdin can only be read once")
+		}
+		stdinRead = true
+		b, err := io.ReadAll(src.Stdin)
+		if err != nil {
+			return fmt.Errorf("reading stdin: %w", err)
+		}
+		inputs = append(inputs, Input{Name: "stdin", Text: string(b)})
+		return nil
+	}
+
+	for _, arg := range src.Args {
+		if arg == "-" {
+			if err := readStdin(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		inputs = append(inputs, Input{Text: arg})
+	}
+	paths, err := Expand(src.Files)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, Input{Name: path, Text: string(b)})
+	}
+	if len(inputs) == 0 && src.StdinPiped {
+		if err := readStdin(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, in := range inputs {
+		if strings.TrimSpace(in.Text) == "" {
+			if in.Name == "" {
+				return nil, errors.New("please provide some text to edit")
+			}
+			return nil, fmt.Errorf("%s is empty, nothing to edit", in.Name)
+		}
+	}
+	return inputs, nil
+}
+
+// Expand returns the files matching patterns, in order and without
+// duplicates. Patterns without glob characters are kept as they are, so a
+// missing file is reported when it is read.
+func Expand(patterns []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if matches == nil {
+			if hasMeta(pattern) {
+				return nil, fmt.Errorf("no files match %q", pattern)
+			}
+			matches = []string{pattern}
+		}
+		for _, path := range matches {
+			if !seen[filepath.Clean(path)] {
+				seen[filepath.Clean(path)] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths, nil
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// Output is where the CLI writes the edited texts.
+type Output struct {
+	io.Writer
+	file *os.File // temporary file renamed to path by Commit
+	path string
+}
+
+// NewOutput returns an Output writing to path, or to stdout if path is "".
+// A file is only replaced by Commit, once everything was written, so path
+// may be one of the inputs and is left alone if an edit fails.
+func NewOutput(path string) (*Output, error) {
+	if path == "" {
+		return &Output{Writer: os.Stdout}, nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &Output{Writer: f, file: f, path: path}, nil
+}
+
+// Commit moves the written file into place, keeping the mode of the file it
+// replaces.
+func (o *Output) Commit() error {
+	if o.file == nil {
+		return nil
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(o.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err := o.file.Chmod(mode)
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(o.file.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(o.file.Name())
+		return fmt.Errorf("writing %s: %w", o.path, err)
+	}
+	o.file = nil
+	return nil
+}
+
+// Discard drops what was written, leaving the file at path as it was. It
+// does nothing after Commit.
+func (o *Output) Discard() {
+	if o.file == nil {
+		return
+	}
+	o.file.Close()
+	os.Remove(o.file.Name())
+	o.file = nil
+}
@@ -0,0 +1,115 @@
+package textio
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRead(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.md": "alpha", "b.md": "beta", "c.txt": "gamma"})
+	md := filepath.Join(dir, "*.md")
+	b := filepath.Join(dir, "b.md")
+	c := filepath.Join(dir, "c.txt")
+
+	tests := []struct {
+		name string
+		src  Sources
+		want []Input
+	}{
+		{"argument", Sources{Args: []string{"I has went."}}, []Input{{"", "I has went."}}},
+		{"dash", Sources{Args: []string{"-"}, Stdin: strings.NewReader("from a pipe\n")}, []Input{{"stdin", "from a pipe\n"}}},
+		{
+			"piped stdin",
+			Sources{Stdin: strings.NewReader("Fix typo in the parser\n"), StdinPiped: true},
+			[]Input{{"stdin", "Fix typo in the parser\n"}},
+		},
+		{
+			"piped stdin with files",
+			Sources{Files: []string{c}, Stdin: strings.NewReader("ignored"), StdinPiped: true},
+			[]Input{{c, "gamma"}},
+		},
+		{
+			"globs in order, once each",
+			Sources{Files: []string{b, md, c}},
+			[]Input{{b, "beta"}, {filepath.Join(dir, "a.md"), "alpha"}, {c, "gamma"}},
+		},
+		{"nothing", Sources{Stdin: strings.NewReader("ignored")}, nil},
+	}
+	for _, tt := range tests {
+		got, err := Read(tt.src)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Read = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"empty.txt": "\n"})
+	tests := []struct {
+		name    string
+		src     Sources
+		wantErr string
+	}{
+		{"empty argument", Sources{Args: []string{""}}, "provide some text"},
+		{"empty file", Sources{Files: []string{filepath.Join(dir, "empty.txt")}}, "empty.txt is empty"},
+		{"missing file", Sources{Files: []string{filepath.Join(dir, "missing.txt")}}, "missing.txt"},
+		{"unmatched glob", Sources{Files: []string{filepath.Join(dir, "*.md")}}, "no files match"},
+		{"stdin twice", Sources{Args: []string{"-", "-"}, Stdin: strings.NewReader("x")}, "only be read once"},
+	}
+	for _, tt := range tests {
+		if _, err := Read(tt.src); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"notes.md": "I has went."})
+	path := filepath.Join(dir, "notes.md")
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	discarded, err := NewOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discarded.Write([]byte("half an edit"))
+	discarded.Discard()
+	if b, _ := os.ReadFile(path); string(b) != "I has went." {
+		t.Errorf("after Discard the file holds %q", b)
+	}
+
+	out, err := NewOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte("I went.\n"))
+	if err := out.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	out.Discard() // deferred by callers, must not undo the commit
+	if b, _ := os.ReadFile(path); string(b) != "I went.\n" {
+		t.Errorf("after Commit the file holds %q", b)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want the original 0600", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
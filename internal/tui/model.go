@@ -2,9 +2,9 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/atotto/clipboard"
@@ -19,6 +19,9 @@ import (
 	"github.com/muesli/reflow/wordwrap"
 	"github.com/sergi/go-diff/diffmatchpatch"
 
+	"github.com/muzzlol/nomodit/internal/chunk"
+	"github.com/muzzlol/nomodit/internal/meaning"
+	"github.com/muzzlol/nomodit/internal/prompt"
 	"github.com/muzzlol/nomodit/pkg/llama"
 
 	"github.com/spf13/viper"
@@ -49,6 +52,7 @@ var (
 	textStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("252"))                   // Light Gray
 	deletedStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Strikethrough(true) // Bright Red
 	addedStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))                    // Bright Green
+	unsureStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Underline(true)    // Yellow, for insertions the model was unsure about
 
 	submitFocusedButton = focusedButtonStyle.Render("[ Submit ]")
 	submitBlurredButton = blurredButtonStyle.Render("[ Submit ]")
@@ -57,7 +61,17 @@ var (
 )
 
 type model struct {
-	server           *llama.Server
+	backend          llama.Backend
+	sampling         llama.Sampling
+	structured       bool
+	mode             prompt.Mode
+	chunkTokens      int
+	cachePrompt      bool
+	lora             []llama.LoRA
+	embedder         llama.Embedder
+	minSimilarity    float64
+	rejectChanges    bool               // discard edits that fail the meaning check
+	decoder          *llama.EditDecoder // set while a structured response streams
 	serverReady      bool
 	llm              string
 	title            string
@@ -69,27 +83,44 @@ type model struct {
 	suggestionsHelp  help.Model
 	suggestionKeys   keyMap
 	statusChan       <-chan llama.ServerStatus
-	inferenceChan    <-chan llama.InferenceResp
+	progress         *llama.Progress // last download progress while starting up
+	inferenceChan    <-chan chunk.Event
+	cancelInference  context.CancelFunc
 	isInferring      bool
 	inferenceBuilder strings.Builder
+	probs            []llama.TokenProb // of the response streaming in
+	reasoningBuilder strings.Builder
+	reasoningView    viewport.Model
+	showReasoning    bool
 	output           viewport.Model
 	response         string
+	result           *llama.Result // metrics of the last finished generation
 	width            int
 	height           int
 }
 
-func diffing(og, new string) string {
+// unsureProb is the token probability below which an insertion is shown
+// with unsureStyle.
+const unsureProb = 0.5
+
+// diffing renders the word diff from og to new. conf, if set, is the
+// probability of each byte of new (see llama.Confidence), used to tell the
+// insertions the model was unsure about.
+func diffing(og, new string, conf []float64) string {
 	dmp := diffmatchpatch.New()
 	diffs := dmp.DiffMain(og, new, false)
 	diffs = dmp.DiffCleanupSemantic(diffs)
 	s := wordwrap.NewWriter(98)
+	pos := 0 // of diff in new
 	for _, diff := range diffs {
 		var text string
 		switch diff.Type {
 		case diffmatchpatch.DiffEqual:
 			text = textStyle.Render(diff.Text)
+			pos += len(diff.Text)
 		case diffmatchpatch.DiffInsert:
-			text = addedStyle.Render(diff.Text)
+			text = renderInsert(diff.Text, conf, pos)
+			pos += len(diff.Text)
 		case diffmatchpatch.DiffDelete:
 			text = deletedStyle.Render(diff.Text)
 		}
@@ -102,28 +133,97 @@ func diffing(og, new string) string {
 	return s.String()
 }
 
-func setupLogger() {
-	// Log to a file for debugging purposes
-	f, err := os.OpenFile("nomodit.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+// renderInsert renders inserted, found at pos in the edited text, with
+// addedStyle, and its parts the model was unsure about with unsureStyle.
+func renderInsert(inserted string, conf []float64, pos int) string {
+	if conf == nil {
+		return addedStyle.Render(inserted)
+	}
+	var b strings.Builder
+	start, unsure := 0, false
+	flush := func(end int) {
+		if end > start {
+			style := addedStyle
+			if unsure {
+				style = unsureStyle
+			}
+			b.WriteString(style.Render(inserted[start:end]))
+		}
+		start = end
+	}
+	for i := range inserted {
+		if u := conf[pos+i] < unsureProb; u != unsure {
+			flush(i)
+			unsure = u
+		}
 	}
-	log.SetOutput(f)
-	log.Println("--- Log Start ---")
+	flush(len(inserted))
+	return b.String()
 }
 
-func Launch(instruction string) {
-	setupLogger()
-	m := InitialModel(instruction)
-	defer func() {
-		if m.server != nil {
-			m.server.Stop()
-		}
-	}()
+// renderEdits lists the edits reported in structured mode below the diff.
+func renderEdits(edits []llama.Edit) string {
+	if len(edits) == 0 {
+		return ""
+	}
+	s := wordwrap.NewWriter(98)
+	fmt.Fprint(s, "\n\n"+accentStyle.Render("Edits:"))
+	for _, edit := range edits {
+		fmt.Fprintf(s, "\n• %s → %s %s\n  %s",
+			deletedStyle.Render(edit.Original),
+			addedStyle.Render(edit.Replacement),
+			blurredInputStyle.Render("("+edit.Category+")"),
+			textStyle.Render(edit.Explanation),
+		)
+	}
+	_ = s.Close()
+	return s.String()
+}
+
+// Options configures a TUI session.
+type Options struct {
+	Instruction string
+	Sampling    llama.Sampling
+	// Structured asks the model for a llama.EditResult so each edit can be
+	// listed with its category and explanation.
+	Structured bool
+	// Mode picks raw completion or chat-templated requests.
+	Mode prompt.Mode
+	// ChunkTokens is the size above which texts are edited in parts, see
+	// chunk.Plan.
+	ChunkTokens int
+	// CachePrompt reuses the instruction's prompt prefix across edits, see
+	// prompt.Spec.CachePrompt.
+	CachePrompt bool
+	// LoRA are the adapters llama-server loaded. Each edit uses the one for
+	// its instruction's task, see prompt.Adapter.
+	LoRA []llama.LoRA
+	// Embedder, if set, checks that edits keep the meaning of the text:
+	// edits scoring below MinSimilarity are flagged, or discarded with
+	// RejectMeaningChange.
+	Embedder            llama.Embedder
+	MinSimilarity       float64
+	RejectMeaningChange bool
+}
+
+// Launch runs the TUI until the user quits or ctx is cancelled. The backend is
+// always stopped before Launch returns, so no llama-server is left behind.
+// The standard logger must not write to the terminal, see logs.Setup.
+func Launch(ctx context.Context, backend llama.Backend, opts Options) error {
+	defer stopBackend(backend)
+	m := InitialModel(backend, opts)
+
+	p := tea.NewProgram(m, tea.WithContext(ctx))
+	if _, err := p.Run(); err != nil && !errors.Is(err, tea.ErrProgramKilled) {
+		return err
+	}
+	return nil
+}
 
-	p := tea.NewProgram(m)
-	if _, err := p.Run(); err != nil {
-		log.Fatal(err)
+func stopBackend(backend llama.Backend) {
+	backend.Stop()
+	if w, ok := backend.(interface{ Wait() error }); ok {
+		log.Printf("llama-server exited: %v", w.Wait())
 	}
 }
 
@@ -200,25 +300,41 @@ func newFtextarea() *fTextarea {
 
 type serverStatusMsg llama.ServerStatus
 type serverReadyMsg struct{}
-type inferenceMsg llama.InferenceResp
+type inferenceMsg chunk.Event
 type inferenceDoneMsg struct{}
 
+// plannedMsg carries the job planned for a submitted text, see plan.
+type plannedMsg struct {
+	ctx context.Context // of the request
+	job *chunk.Job
+	err error
+}
+
+// meaningMsg is the outcome of checking an edit against its original.
+type meaningMsg struct {
+	original, edited string
+	verdict          meaning.Verdict
+	err              error
+}
+
 type keyMap struct {
 	Navigation key.Binding
 	Submit     key.Binding
 	Quit       key.Binding
 	Scroll     key.Binding
 	Clear      key.Binding
+	Cancel     key.Binding
+	Reasoning  key.Binding
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Navigation, k.Submit, k.Quit, k.Scroll, k.Clear}
+	return []key.Binding{k.Navigation, k.Submit, k.Quit, k.Scroll, k.Clear, k.Cancel, k.Reasoning}
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Navigation, k.Submit, k.Quit},
-		{k.Scroll, k.Clear},
+		{k.Scroll, k.Clear, k.Cancel, k.Reasoning},
 	}
 }
 
@@ -243,6 +359,14 @@ var keys = keyMap{
 		key.WithKeys("ctrl+l"),
 		key.WithHelp("ctrl+l", "clear input"),
 	),
+	Cancel: key.NewBinding(
+		key.WithKeys("ctrl+x"),
+		key.WithHelp("ctrl+x", "stop generation"),
+	),
+	Reasoning: key.NewBinding(
+		key.WithKeys("ctrl+r"),
+		key.WithHelp("ctrl+r", "toggle reasoning"),
+	),
 }
 
 var suggestionKeys = keyMap{
@@ -261,7 +385,7 @@ type state struct {
 	spinner spinner.Model
 }
 
-func InitialModel(instruction string) *model {
+func InitialModel(backend llama.Backend, opts Options) *model {
 	// Create wrapper instances
 	output := viewport.New(100, 20)
 	output.Style = lipgloss.NewStyle().
@@ -270,8 +394,15 @@ func InitialModel(instruction string) *model {
 		Width(100).
 		Height(20)
 
+	reasoningView := viewport.New(100, 8)
+	reasoningView.Style = lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("240")).
+		Width(100).
+		Height(8)
+
 	instructions := newFtextinput()
-	instructions.Model.SetValue(instruction)
+	instructions.Model.SetValue(opts.Instruction)
 	instructions.Model.SetSuggestions([]string{"Fix grammar and improve clarity of this text", "Fix grammar", "Fix grammar in this sentence", "Fix grammar in the sentence", "Fix grammar errors", "Fix grammatical errors", "Fix grammaticality", "Fix all grammatical errors", "Fix grammatical errors in this sentence", "Fix grammar errors in this sentence", "Fix grammatical mistakes in this sentence", "Fix grammaticality in this sentence", "Fix grammaticality of the sentence", "Fix disfluencies in the sentence", "Make the sentence grammatical", "Make the sentence fluent", "Fix errors in this text", "Update to remove grammar errors", "Remove all grammatical errors from this text", "Improve the grammar of this text", "Improve the grammaticality", "Improve the grammaticality of this text", "Improve the grammaticality of this sentence", "Grammar improvements", "Remove grammar mistakes", "Remove grammatical mistakes", "Fix the grammar mistakes", "Fix grammatical mistakes", "Clarify the sentence", "Clarify this sentence", "Clarify this text", "Write a clearer version for the sentence", "Write a clarified version of the sentence", "Write a readable version of the sentence", "Write a better readable version of the sentence", "Rewrite the sentence more clearly", "Rewrite this sentence clearly", "Rewrite this sentence for clarity", "Rewrite this sentence for readability", "Improve this sentence for readability", "Make this sentence better readable", "Make this sentence more readable", "Make this sentence readable", "Make the sentence clear", "Make the sentence clearer", "Clarify", "Make the text more understandable", "Make this easier to read", "Clarification", "Change to clearer wording", "Clarify this paragraph", "Use clearer wording", "Simplify the sentence", "Simplify this sentence", "Simplify this text", "Write a simpler version for the sentence", "Rewrite the sentence to be simpler", "Rewrite this sentence in a simpler manner", "Rewrite this sentence for simplicity", "Rewrite this with simpler wording", "Make the sentence simple", "Make the sentence simpler", "Make this text less complex", "Make this simpler", "Simplify", "Simplification", "Change to simpler wording", "Simplify this paragraph", "Simplify this text", "Use simpler wording", "Make this easier to understand", "Fix coherence", "Fix coherence in this sentence", "Fix coherence in the sentence", "Fix coherence in this text", "Fix coherence in the text", "Fix coherence errors", "Fix sentence flow", "Fix sentence transition", "Fix coherence errors in this sentence", "Fix coherence mistakes in this sentence", "Fix coherence in this sentence", "Fix coherence of the sentence", "Fix lack of coherence in the sentence", "Make the text more coherent", "Make the text coherent", "Make the text more cohesive", "Make the text more cohesive, logically linked and consistent as a whole", "Make the text more logical", "Make the text more consistent", "Improve the cohesiveness of the text", "Improve the consistency of the text", "Make the text clearer", "Improve the coherence of the text", "Formalize", "Improve formality", "Formalize the sentence", "Formalize this sentence", "Formalize the text", "Formalize this text", "Make this formal", "Make this more formal", "Make this sound more formal", "Make the sentence formal", "Make the sentence more formal", "Make the sentence sound more formal", "Write more formally", "Write less informally", "Rewrite more formally", "Write this more formally", "Rewrite this more formally", "Write in a formal manner", "Write in a more formal manner", "Rewrite in a more formal manner", "Remove POV", "Remove POVs", "Remove POV in this text", "Remove POVs in this text", "Neutralize this text", "Neutralize the text", "Neutralize this sentence", "Neutralize the sentence", "Make this more neutral", "Make this text more neutral", "Make this sentence more neutral", "Make this paragraph more neutral", "Remove unsourced opinions", "Remove unsourced opinions from this text", "Remove non-neutral POVs", "Remove non-neutral POV", "Remove non-neutral points of view", "Remove points of view", "Make this text less biased", "Paraphrase the sentence", "Paraphrase this sentence", "Paraphrase this text", "Paraphrase", "Write a paraphrase for the sentence", "Write a paraphrased version of the sentence", "Rewrite the sentence with different wording", "Use different wording", "Rewrite this sentence", "Reword this sentence", "Rephrase this sentence", "Rewrite this text", "Reword this text", "Rephrase this text"})
 	instructions.Model.KeyMap.AcceptSuggestion = key.NewBinding(
 		key.WithKeys("enter"),
@@ -290,9 +421,19 @@ func InitialModel(instruction string) *model {
 	spinner := spinner.New(spinner.WithSpinner(spinner.Line), spinner.WithStyle(accentStyle))
 
 	m := model{
-		llm:         viper.GetString("llm"),
-		serverReady: false,
-		title:       accentStyle.Render(title),
+		backend:       backend,
+		sampling:      opts.Sampling,
+		structured:    opts.Structured,
+		mode:          opts.Mode,
+		chunkTokens:   opts.ChunkTokens,
+		cachePrompt:   opts.CachePrompt,
+		lora:          opts.LoRA,
+		embedder:      opts.Embedder,
+		rejectChanges: opts.RejectMeaningChange,
+		minSimilarity: opts.MinSimilarity,
+		llm:           viper.GetString("llm"),
+		serverReady:   false,
+		title:         accentStyle.Render(title),
 		currentState: state{
 			text:    accentStyle.Render("hi"),
 			spinner: spinner,
@@ -305,6 +446,7 @@ func InitialModel(instruction string) *model {
 		suggestionKeys:  suggestionKeys,
 		isInferring:     false,
 		output:          output,
+		reasoningView:   reasoningView,
 		width:           100,
 		height:          24,
 	}
@@ -318,33 +460,133 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.currentState.text = dangerStyle.Render(msg.Message)
 			return m, tea.Quit
 		}
+		if msg.Ready {
+			// a supervised backend keeps reporting after this, e.g. restarts
+			m.setReady()
+			return m, m.checkServerStatus()
+		}
+		m.serverReady = false
 		m.currentState.text = accentStyle.Render(msg.Message)
+		if msg.Progress != nil {
+			m.progress = msg.Progress
+		}
 		return m, m.checkServerStatus()
 	case serverReadyMsg:
-		m.serverReady = true
-		m.currentState.text = accentStyle.Render("Ready! model: " + m.llm)
+		if !m.serverReady {
+			m.setReady()
+		}
 		return m, nil
 	case inferenceMsg:
+		if msg.Err != nil {
+			log.Printf("inference failed: %v", msg.Err)
+			m.stopInference()
+			if errors.Is(msg.Err, context.Canceled) {
+				m.currentState.text = warningStyle.Render("Generation stopped")
+			} else {
+				m.currentState.text = dangerStyle.Render(msg.Err.Error())
+			}
+			return m, nil
+		}
+		if msg.Retry {
+			// the server crashed and the request was re-sent, start over
+			m.inferenceBuilder.Reset()
+			m.reasoningBuilder.Reset()
+			m.probs = nil
+			m.reasoningView.SetContent("")
+			if m.decoder != nil {
+				m.decoder = &llama.EditDecoder{}
+			}
+			m.currentState.text = warningStyle.Render("Server restarted, generating again")
+		}
+		if msg.Chunks > 1 && !msg.Stop {
+			m.currentState.text = accentStyle.Render(fmt.Sprintf("Generating part %d of %d", msg.Chunk, msg.Chunks))
+		}
 		log.Print(msg.Content)
+		if msg.Reasoning != "" {
+			m.reasoningBuilder.WriteString(msg.Reasoning)
+			m.reasoningView.SetContent(wordwrap.String(blurredInputStyle.Render(m.reasoningBuilder.String()), m.reasoningView.Width-2))
+			m.reasoningView.GotoBottom()
+		}
+		m.inferenceBuilder.WriteString(msg.Content)
+		m.probs = append(m.probs, msg.Probs...)
+		if m.decoder != nil {
+			m.decoder.Write(msg.Content)
+		}
 		if msg.Stop {
-			if msg.Content != "" {
-				m.inferenceBuilder.WriteString(msg.Content)
-			}
+			m.result = msg.Result
 			ip := m.focusables[1].(*fTextarea)
 			response := m.inferenceBuilder.String()
-			diff := diffing(ip.Model.Value(), response)
+			var edits []llama.Edit
+			if m.decoder != nil {
+				result, err := m.decoder.Result()
+				if err != nil {
+					log.Printf("structured output: %v\n%s", err, response)
+					m.stopInference()
+					m.currentState.text = dangerStyle.Render(err.Error())
+					return m, nil
+				}
+				response, edits = result.EditedText, result.Edits
+			}
 			m.response = response
-			m.output.SetContent(diff)
+			var conf []float64
+			if len(m.probs) > 0 {
+				conf = llama.Confidence(response, m.probs)
+			}
+			m.output.SetContent(diffing(ip.Model.Value(), response, conf) + renderEdits(edits))
 			m.output.GotoBottom()
-			return m, func() tea.Msg { return inferenceDoneMsg{} }
+			return m, tea.Batch(
+				func() tea.Msg { return inferenceDoneMsg{} },
+				m.checkMeaning(ip.Model.Value(), response),
+			)
+		}
+		if m.decoder != nil {
+			// show edited_text as it streams rather than the raw JSON
+			m.output.SetContent(m.decoder.EditedText())
+		} else {
+			m.output.SetContent(m.inferenceBuilder.String())
 		}
-		m.inferenceBuilder.WriteString(msg.Content)
-		m.output.SetContent(m.inferenceBuilder.String())
 		m.output.GotoBottom()
 		return m, m.checkInference()
+	case plannedMsg:
+		if !m.isInferring {
+			return m, nil // quit or failed meanwhile
+		}
+		switch {
+		case errors.Is(msg.err, prompt.ErrInputTooLong):
+			m.currentState.text = dangerStyle.Render(msg.err.Error())
+			m.stopInference()
+			return m, nil
+		case msg.job.Tight():
+			m.currentState.text = warningStyle.Render("Generating, the text barely fits the context so the answer may be cut short")
+		}
+		// a cancelled plan still runs, so the stream reports it like any other
+		m.inferenceChan = msg.job.Run(msg.ctx, m.backend)
+		return m, m.checkInference()
 	case inferenceDoneMsg:
-		m.isInferring = false
-		m.currentState.text = accentStyle.Render("Done!")
+		m.stopInference()
+		status := "Done!"
+		if m.result != nil {
+			status += " " + m.result.Summary()
+		}
+		m.currentState.text = accentStyle.Render(status)
+		return m, nil
+	case meaningMsg:
+		if m.isInferring || msg.edited != m.response {
+			return m, nil // a newer edit is on screen
+		}
+		switch {
+		case msg.err != nil:
+			log.Printf("meaning check: %v", msg.err)
+			m.currentState.text = warningStyle.Render("Meaning check skipped: " + msg.err.Error())
+		case msg.verdict.Changed() && m.rejectChanges:
+			m.response = ""
+			m.output.SetContent(dangerStyle.Render("Edit rejected: "+msg.verdict.String()) + gap + diffing(msg.original, msg.edited, nil))
+			m.currentState.text = dangerStyle.Render("Edit rejected, " + msg.verdict.String())
+		case msg.verdict.Changed():
+			m.currentState.text = warningStyle.Render("Warning: " + msg.verdict.String())
+		default:
+			log.Print(msg.verdict)
+		}
 		return m, nil
 	case spinner.TickMsg:
 		var cmd tea.Cmd
@@ -356,6 +598,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Update viewport width to be responsive
 		viewportWidth := min(100, m.width-4) // 4 for padding
 		m.output.Width = viewportWidth
+		m.reasoningView.Width = viewportWidth
 		// Update input width
 		if len(m.focusables) > 1 {
 			if ta, ok := m.focusables[1].(*fTextarea); ok {
@@ -366,8 +609,17 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, m.keys.Quit):
-			m.server.Stop()
+			m.stopInference()
+			m.backend.Stop()
 			return m, tea.Quit
+		case key.Matches(msg, m.keys.Reasoning):
+			m.showReasoning = !m.showReasoning
+			return m, nil
+		case key.Matches(msg, m.keys.Cancel):
+			if m.isInferring && m.cancelInference != nil {
+				m.cancelInference() // the stream reports context.Canceled as its last event
+			}
+			return m, nil
 		case key.Matches(msg, m.keys.Submit):
 			if m.focusIndex == -1 {
 				if m.response == "" {
@@ -394,27 +646,34 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				m.isInferring = true
 				m.inferenceBuilder.Reset()
+				m.probs = nil
+				m.result = nil
+				m.reasoningBuilder.Reset()
+				m.reasoningView.SetContent("")
 				m.output.SetContent("")
 				m.currentState.text = accentStyle.Render("Generating")
 				m.currentState.spinner = spinner.New(spinner.WithSpinner(spinner.Points), spinner.WithStyle(accentStyle))
 
-				prompt := fmt.Sprintf("Instruction: %s\nText to fix: \"%s\"\n\nRespond with ONLY the fixed text, without any additional explanations, comments, or introductory phrases like \"Fixed text:\".", instructions, ip.Model.Value())
-
-				req := llama.InferenceReq{
-					Prompt:   prompt,
-					Temp:     0.2,
-					NPredict: 200,
+				spec := prompt.Spec{
+					Instruction: instructions,
+					Text:        ip.Model.Value(),
+					Mode:        m.mode,
+					Structured:  m.structured,
+					Sampling:    m.sampling,
+					NPredict:    200,
+					CachePrompt: m.cachePrompt,
+					Probs:       true,
+					LoRA:        prompt.Adapter(instructions, m.lora),
 				}
-
-				var err error
-				m.inferenceChan, err = m.server.Inference(req)
-				if err != nil {
-					m.currentState.text = dangerStyle.Render(err.Error())
-					m.isInferring = false
-					return m, nil
+				m.decoder = nil
+				if m.structured {
+					spec.NPredict = 1024 // room for the per-edit explanations
+					m.decoder = &llama.EditDecoder{}
 				}
 
-				return m, tea.Batch(m.currentState.spinner.Tick, m.checkInference())
+				var ctx context.Context
+				ctx, m.cancelInference = context.WithCancel(context.Background())
+				return m, tea.Batch(m.currentState.spinner.Tick, m.plan(ctx, spec))
 			}
 		case key.Matches(msg, m.keys.Clear):
 			if m.focusIndex == 0 {
@@ -466,6 +725,38 @@ func (m *model) updateFocusables(msg tea.Msg) tea.Cmd {
 	return cmd
 }
 
+// stopInference clears the in-flight generation state, cancelling the request
+// if it is still running.
+func (m *model) stopInference() {
+	if m.cancelInference != nil {
+		m.cancelInference()
+		m.cancelInference = nil
+	}
+	m.isInferring = false
+}
+
+// plan measures spec in the background and splits it into parts if needed.
+// Tokenizing, and waiting for a restarting server, would otherwise block the
+// event loop. Without a tokenizer, or if measuring fails, spec is sent whole.
+func (m *model) plan(ctx context.Context, spec prompt.Spec) tea.Cmd {
+	tok, ok := m.backend.(llama.Tokenizer)
+	if !ok {
+		return func() tea.Msg { return plannedMsg{ctx: ctx, job: chunk.Single(spec)} }
+	}
+	chunkTokens := m.chunkTokens
+	return func() tea.Msg {
+		job, err := chunk.Plan(ctx, tok, spec, chunkTokens)
+		switch {
+		case errors.Is(err, prompt.ErrInputTooLong):
+			return plannedMsg{ctx: ctx, err: err}
+		case err != nil:
+			log.Printf("could not measure the prompt: %v", err)
+			job = chunk.Single(spec)
+		}
+		return plannedMsg{ctx: ctx, job: job}
+	}
+}
+
 func (m *model) checkInference() tea.Cmd {
 	return func() tea.Msg {
 		res, ok := <-m.inferenceChan
@@ -476,6 +767,29 @@ func (m *model) checkInference() tea.Cmd {
 	}
 }
 
+// checkMeaning compares edited with original in the background, if there is
+// an embedder to do it.
+func (m *model) checkMeaning(original, edited string) tea.Cmd {
+	if m.embedder == nil {
+		return nil
+	}
+	embedder, threshold := m.embedder, m.minSimilarity
+	return func() tea.Msg {
+		verdict, err := meaning.Check(context.Background(), embedder, original, edited, threshold)
+		return meaningMsg{original: original, edited: edited, verdict: verdict, err: err}
+	}
+}
+
+func (m *model) setReady() {
+	m.serverReady = true
+	m.progress = nil
+	if m.isInferring {
+		m.currentState.text = accentStyle.Render("Generating")
+	} else {
+		m.currentState.text = accentStyle.Render("Ready! model: " + m.llm)
+	}
+}
+
 func (m *model) checkServerStatus() tea.Cmd {
 	return func() tea.Msg {
 		status, ok := <-m.statusChan
@@ -487,13 +801,14 @@ func (m *model) checkServerStatus() tea.Cmd {
 }
 
 func (m *model) Init() tea.Cmd {
-	server, err := llama.StartServer(m.llm, "8091")
-	if err != nil {
+	if err := m.backend.Start(); err != nil {
 		m.currentState.text = dangerStyle.Render("Fatal: " + err.Error())
 		return tea.Quit
 	}
-	m.server = server
-	m.statusChan = m.server.StatusUpdates(context.Background())
+	if server, ok := m.backend.(interface{ CommandLine() string }); ok && server.CommandLine() != "" {
+		log.Printf("launched %s", server.CommandLine())
+	}
+	m.statusChan = m.backend.StatusUpdates(context.Background())
 	return tea.Batch(
 		m.focusables[0].Focus(),
 		m.currentState.spinner.Tick,
@@ -529,8 +844,26 @@ func (m *model) View() string {
 	}
 	centeredStatus := lipgloss.PlaceHorizontal(m.width, lipgloss.Center, statusText)
 	s.WriteString(centeredStatus)
+	if m.progress != nil {
+		bar := accentStyle.Render(m.progress.Bar(40)) + " " + blurredInputStyle.Render(m.progress.String())
+		s.WriteString("\n" + lipgloss.PlaceHorizontal(m.width, lipgloss.Center, bar))
+	}
 	s.WriteString(gap)
 
+	// Collapsible reasoning pane, only once the model has produced some
+	if m.reasoningBuilder.Len() > 0 {
+		header := "▸ Reasoning (ctrl+r to show)"
+		if m.showReasoning {
+			header = "▾ Reasoning (ctrl+r to hide)"
+		}
+		s.WriteString(lipgloss.PlaceHorizontal(m.width, lipgloss.Center, accentStyle.Render(header)))
+		s.WriteString("\n")
+		if m.showReasoning {
+			s.WriteString(lipgloss.PlaceHorizontal(m.width, lipgloss.Center, m.reasoningView.View()))
+			s.WriteString("\n")
+		}
+	}
+
 	// Center the copy button
 	copyButton := copyBlurredButton
 	if m.focusIndex == -1 {
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode"
 
 	"github.com/spf13/viper"
 )
@@ -27,8 +31,10 @@ func Load() error {
 			if home != "" {
 				dir := filepath.Join(home, ".nomodit")
 				if err := os.MkdirAll(dir, os.ModePerm); err == nil {
-					if err := viper.WriteConfigAs(filepath.Join(dir, "config.env")); err != nil {
-
+					// an empty file to fill in, rather than a dump of every default
+					f, err := os.OpenFile(filepath.Join(dir, "config.env"), os.O_CREATE|os.O_WRONLY, 0o644)
+					if err == nil {
+						f.Close()
 					}
 				}
 
@@ -40,14 +46,71 @@ func Load() error {
 	return nil
 }
 
+// Save records the current llm in ~/.nomodit/config.env. Only llm is saved:
+// flags such as --server-url or --structured are for the run they are given
+// to, and neither they nor defaults or environment variables end up in the
+// file. Only the LLM line is rewritten, the rest of the file, comments and
+// quoting included, is kept as written.
 func Save() error {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return err
 	}
-	dir := filepath.Join(home, ".nomodit")
-	if err := viper.WriteConfigAs(filepath.Join(dir, "config.env")); err != nil {
+	path := filepath.Join(home, ".nomodit", "config.env")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
-	return nil
+
+	llm := "LLM=" + viper.GetString("llm")
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	saved := false
+	for i, line := range lines {
+		if envKey(line) == "LLM" {
+			lines[i], saved = llm, true
+		}
+	}
+	if !saved {
+		lines = append(lines, llm)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
+
+// envKey returns the upper-cased key set by a config.env line, or "" for
+// comments and blank lines.
+func envKey(line string) string {
+	line = strings.TrimPrefix(strings.TrimSpace(line), "export ")
+	key, _, ok := strings.Cut(line, "=")
+	if !ok || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(key))
+}
+
+// ModelString returns key for llm: a per-model override such as
+// MODEL_UNSLOTH_GEMMA_3_1B_IT_GGUF_MODE if one is set, otherwise key itself.
+func ModelString(llm, key string) string {
+	if k := ModelKey(llm, key); viper.IsSet(k) {
+		return viper.GetString(k)
+	}
+	return viper.GetString(key)
+}
+
+// ModelKey returns the config key overriding key for llm alone. Everything but
+// letters and digits becomes an underscore so the key also works as an
+// environment variable.
+func ModelKey(llm, key string) string {
+	slug := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, llm)
+	return "model_" + slug + "_" + key
 }
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+	"github.com/spf13/viper"
+)
+
+func TestModelString(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	const llm = "unsloth/gemma-3-1b-it-GGUF"
+
+	if got := ModelKey(llm, "mode"); got != "model_unsloth_gemma_3_1b_it_gguf_mode" {
+		t.Errorf("ModelKey = %q", got)
+	}
+
+	viper.Set("mode", "chat")
+	if got := ModelString(llm, "mode"); got != "chat" {
+		t.Errorf("without an override got %q, want the global %q", got, "chat")
+	}
+	viper.Set(ModelKey(llm, "mode"), "completion")
+	if got := ModelString(llm, "mode"); got != "completion" {
+		t.Errorf("with an override got %q, want %q", got, "completion")
+	}
+	if got := ModelString("other/model", "mode"); got != "chat" {
+		t.Errorf("override leaked to another model: %q", got)
+	}
+}
+
+func TestLaunchOptions(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	const llm = "unsloth/gemma-3-1b-it-GGUF"
+
+	viper.Set("ctx_size", "4096")
+	viper.Set("n_gpu_layers", "0")
+	viper.Set("mlock", "true")
+	viper.Set("llama_args", "--no-webui  --cache-type-k q8_0")
+	viper.Set(ModelKey(llm, "ctx_size"), "8192")
+	viper.Set(ModelKey(llm, "lora"), "grammar=/adapters/gec.gguf, simplify=/adapters/simple.gguf")
+
+	opts, err := LaunchOptions(llm)
+	if err != nil {
+		t.Fatalf("LaunchOptions: %v", err)
+	}
+	if opts.CtxSize != 8192 || opts.GPULayers != "0" || !opts.MLock {
+		t.Errorf("opts = %+v, want the per-model ctx size over the global one", opts)
+	}
+	if got := strings.Join(opts.ExtraArgs, "|"); got != "--no-webui|--cache-type-k|q8_0" {
+		t.Errorf("ExtraArgs = %q", opts.ExtraArgs)
+	}
+	if len(opts.LoRA) != 2 || opts.LoRA[1] != (llama.LoRA{Name: "simplify", Path: "/adapters/simple.gguf"}) {
+		t.Errorf("LoRA = %+v", opts.LoRA)
+	}
+	if opts, _ := LaunchOptions("other/model"); opts.CtxSize != 4096 || opts.LoRA != nil {
+		t.Errorf("other model ctx size = %d, LoRA = %v; want the global 4096 and no adapters", opts.CtxSize, opts.LoRA)
+	}
+
+	viper.Set("threads", "lots")
+	if _, err := LaunchOptions(llm); err == nil || !strings.Contains(err.Error(), "THREADS") {
+		t.Errorf("invalid threads: err = %v", err)
+	}
+}
+
+func TestSave(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := filepath.Join(home, ".nomodit", "config.env")
+	// comments and quoting the env encoder would lose
+	keep := "# my settings\nMODE=chat\nSTOP='[\"###\"]'\n"
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(keep+"LLM=old/model-GGUF\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("llm", "new/model-GGUF")
+	viper.Set("server_url", "http://127.0.0.1:1") // a one-off flag
+	viper.SetDefault("chunk_tokens", 1024)
+	if err := Save(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	if want := keep + "LLM=new/model-GGUF\n"; got != want {
+		t.Errorf("config =\n%s\nwant\n%s", got, want)
+	}
+	for _, unwanted := range []string{"SERVER_URL", "CHUNK_TOKENS"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("config gained %s:\n%s", unwanted, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+)
+
+// LaunchOptions reads the llama-server launch options for llm from the
+// config, preferring per-model overrides such as
+// MODEL_UNSLOTH_GEMMA_3_1B_IT_GGUF_CTX_SIZE over the global CTX_SIZE.
+func LaunchOptions(llm string) (llama.Options, error) {
+	opts := llama.Options{
+		Binary:    ModelString(llm, "llama_server"),
+		MMProj:    ModelString(llm, "mmproj"),
+		Draft:     ModelString(llm, "draft"),
+		GPULayers: ModelString(llm, "n_gpu_layers"),
+		FlashAttn: ModelString(llm, "flash_attn"),
+		ExtraArgs: strings.Fields(ModelString(llm, "llama_args")),
+	}
+
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"ctx_size", &opts.CtxSize},
+		{"threads", &opts.Threads},
+		{"batch_size", &opts.BatchSize},
+		{"parallel", &opts.Parallel},
+	}
+	for _, opt := range ints {
+		v := ModelString(llm, opt.key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: want a number", strings.ToUpper(opt.key), v)
+		}
+		*opt.dst = n
+	}
+	if v := ModelString(llm, "lora"); v != "" {
+		lora, err := ParseLoRA(strings.Split(v, ","))
+		if err != nil {
+			return opts, err
+		}
+		opts.LoRA = lora
+	}
+	if v := ModelString(llm, "mlock"); v != "" {
+		mlock, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid MLOCK %q: want true or false", v)
+		}
+		opts.MLock = mlock
+	}
+	return opts, nil
+}
+
+// ParseLoRA parses name=path adapter specs, see llama.ParseLoRA.
+func ParseLoRA(specs []string) ([]llama.LoRA, error) {
+	adapters := make([]llama.LoRA, len(specs))
+	for i, spec := range specs {
+		a, err := llama.ParseLoRA(spec)
+		if err != nil {
+			return nil, err
+		}
+		adapters[i] = a
+	}
+	return adapters, nil
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/muzzlol/nomodit/pkg/llama"
+	"github.com/spf13/viper"
+)
+
+const DefaultProfile = "gec"
+
+// Profiles are named sampling presets. Minimal-edit tasks want the model to
+// stay close to the input, while paraphrasing needs room to reword.
+var Profiles = map[string]llama.Sampling{
+	"gec": {
+		Temp:          llama.Float(0.2),
+		TopK:          llama.Int(40),
+		TopP:          llama.Float(0.9),
+		MinP:          llama.Float(0.05),
+		RepeatPenalty: llama.Float(1.0),
+	},
+	"clarity": {
+		Temp:          llama.Float(0.4),
+		TopK:          llama.Int(40),
+		TopP:          llama.Float(0.9),
+		MinP:          llama.Float(0.05),
+		RepeatPenalty: llama.Float(1.05),
+	},
+	"paraphrase": {
+		Temp:            llama.Float(0.8),
+		TopK:            llama.Int(60),
+		TopP:            llama.Float(0.95),
+		MinP:            llama.Float(0.05),
+		RepeatPenalty:   llama.Float(1.1),
+		PresencePenalty: llama.Float(0.3),
+	},
+}
+
+// ProfileNames returns the available profile names, sorted.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sampling returns the sampling parameters of profile (or, if empty, of the
+// configured PROFILE) with any individual parameters set in the config file or
+// NOMODIT_* environment layered on top.
+func Sampling(profile string) (llama.Sampling, error) {
+	if profile == "" {
+		profile = viper.GetString("profile")
+	}
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	s, ok := Profiles[profile]
+	if !ok {
+		return llama.Sampling{}, fmt.Errorf("unknown sampling profile %q (available: %v)", profile, ProfileNames())
+	}
+
+	// new pointers, the profile's are shared
+	setFloat := func(key string, dst **float32) {
+		if viper.IsSet(key) {
+			*dst = llama.Float(float32(viper.GetFloat64(key)))
+		}
+	}
+	setInt := func(key string, dst **int) {
+		if viper.IsSet(key) {
+			*dst = llama.Int(viper.GetInt(key))
+		}
+	}
+	setFloat("temperature", &s.Temp)
+	setInt("top_k", &s.TopK)
+	setFloat("top_p", &s.TopP)
+	setFloat("min_p", &s.MinP)
+	setFloat("typical_p", &s.TypicalP)
+	setFloat("repeat_penalty", &s.RepeatPenalty)
+	setFloat("presence_penalty", &s.PresencePenalty)
+	setInt("seed", &s.Seed)
+	setInt("n_keep", &s.NKeep)
+	if viper.IsSet("stop") {
+		stop, err := stopSequences()
+		if err != nil {
+			return llama.Sampling{}, err
+		}
+		s.Stop = stop
+	}
+	return s, nil
+}
+
+// stopSequences reads STOP, a JSON list such as ["\n\n", "###"] so that stops
+// can hold spaces and newlines. Any other value is a single stop string.
+func stopSequences() ([]string, error) {
+	value, ok := viper.Get("stop").(string)
+	if !ok {
+		return viper.GetStringSlice("stop"), nil
+	}
+	if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		return []string{value}, nil
+	}
+	var stop []string
+	if err := json.Unmarshal([]byte(value), &stop); err != nil {
+		return nil, fmt.Errorf(`STOP is not a JSON list of strings such as ["\n\n", "###"]: %w`, err)
+	}
+	return stop, nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSampling(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	s, err := Sampling("")
+	if err != nil {
+		t.Fatalf("Sampling: %v", err)
+	}
+	if *s.Temp != *Profiles[DefaultProfile].Temp {
+		t.Errorf("default temp = %v, want the %s profile's %v", *s.Temp, DefaultProfile, *Profiles[DefaultProfile].Temp)
+	}
+
+	viper.Set("profile", "paraphrase")
+	viper.Set("top_k", "7") // config.env values are strings
+	viper.Set("stop", `["\n\n", "</s> ###"]`)
+	viper.Set("seed", "0")
+	s, err = Sampling("")
+	if err != nil {
+		t.Fatalf("Sampling: %v", err)
+	}
+	if *s.Temp != *Profiles["paraphrase"].Temp || *s.TopK != 7 {
+		t.Errorf("got temp %v top_k %d, want paraphrase temp with top_k 7", *s.Temp, *s.TopK)
+	}
+	if *Profiles["paraphrase"].TopK != 60 {
+		t.Errorf("the config changed the paraphrase profile itself, top_k = %d", *Profiles["paraphrase"].TopK)
+	}
+	if s.Seed == nil || *s.Seed != 0 {
+		t.Errorf("seed = %v, want an explicit 0", s.Seed)
+	}
+	if len(s.Stop) != 2 || s.Stop[0] != "\n\n" || s.Stop[1] != "</s> ###" {
+		t.Errorf("stop = %q, want the two stops of the JSON list", s.Stop)
+	}
+
+	if s, _ := Sampling("gec"); *s.Temp != *Profiles["gec"].Temp {
+		t.Errorf("an explicit profile should win over PROFILE, got temp %v", *s.Temp)
+	}
+	if _, err := Sampling("nope"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+
+	viper.Set("stop", "</s> ###")
+	if s, _ := Sampling(""); len(s.Stop) != 1 || s.Stop[0] != "</s> ###" {
+		t.Errorf("stop = %q, want a plain value as one stop", s.Stop)
+	}
+	viper.Set("stop", `["###"`)
+	if _, err := Sampling(""); err == nil {
+		t.Error("expected an error for a malformed STOP list")
+	}
+
+	// single quotes keep the # from starting a comment and the \n escapes for JSON
+	viper.Reset() // drop the overrides set above
+	viper.SetConfigType("env")
+	if err := viper.ReadConfig(strings.NewReader(`STOP='["\n\n", "###"]'` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := Sampling(""); len(s.Stop) != 2 || s.Stop[0] != "\n\n" || s.Stop[1] != "###" {
+		t.Errorf("stop from config.env = %q", s.Stop)
+	}
+}
@@ -0,0 +1,369 @@
+package llama
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// partialFileSuffix marks a GGUF file llama-server is still downloading.
+const partialFileSuffix = ".downloadInProgress"
+
+// partialGracePeriod is how recently a partial download must have been
+// written to for it to count as still active.
+const partialGracePeriod = time.Minute
+
+// ErrDownloadActive is returned when removing a partial download that is still
+// being written to.
+var ErrDownloadActive = errors.New("download still in progress")
+
+// CachedModel is one GGUF file in llama.cpp's model cache.
+type CachedModel struct {
+	Repo         string // Hugging Face repo, e.g. "unsloth/gemma-3-1b-it-GGUF"
+	File         string // file name within the repo
+	Quantization string // e.g. "Q4_K_M", empty if it can't be told from the name
+	Path         string
+	Size         int64
+	ModTime      time.Time
+	// Downloading is set for partial .downloadInProgress files.
+	Downloading bool
+}
+
+// Active reports whether a partial download is still being written to.
+func (m CachedModel) Active() bool {
+	return m.Downloading && time.Since(m.ModTime) < partialGracePeriod
+}
+
+// CacheDir returns the directory llama.cpp downloads -hf models into.
+func CacheDir() (string, error) {
+	return getCacheDir()
+}
+
+func getCacheDir() (string, error) {
+	if cacheDir := os.Getenv("LLAMA_CACHE"); cacheDir != "" {
+		return cacheDir, nil
+	}
+
+	var baseDir string
+
+	switch runtime.GOOS {
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		baseDir = filepath.Join(home, "Library", "Caches")
+	case "windows":
+		baseDir = os.Getenv("LOCALAPPDATA")
+		if baseDir == "" {
+			return "", fmt.Errorf("LOCALAPPDATA environment variable not set")
+		}
+	default: // assuming linux-like
+		if cacheHome := os.Getenv("XDG_CACHE_HOME"); cacheHome != "" {
+			baseDir = cacheHome
+		} else {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			baseDir = filepath.Join(home, ".cache")
+		}
+	}
+
+	return filepath.Join(baseDir, "llama.cpp"), nil
+}
+
+// repoPrefix is how llama.cpp names cached files of a repo,
+// e.g. unsloth/Qwen3-1.7B-GGUF -> unsloth_Qwen3-1.7B-GGUF_
+func repoPrefix(repo string) string {
+	return strings.ReplaceAll(repo, "/", "_") + "_"
+}
+
+// defaultQuant is what the Hub resolves a bare repo to when it has that
+// quantization.
+const defaultQuant = "Q4_K_M"
+
+// isModelCached reports whether llm can be loaded without a download. Local
+// files always can; repo:quant needs a complete file of that quantization,
+// a bare repo the file llama-server would resolve it to (see defaultFile).
+func isModelCached(llm string, cacheDir string) (bool, error) {
+	ref, err := ParseModelRef(llm)
+	if err != nil {
+		return false, err
+	}
+	if ref.Local() {
+		return true, nil
+	}
+	models, err := listCachedModels(cacheDir)
+	if err != nil {
+		return false, err
+	}
+	file, bare := "", ref.Quant == ""
+	if bare {
+		file = defaultFile(cacheDir, ref.Repo)
+	}
+	for _, m := range modelsOf(models, ref) {
+		if m.Downloading {
+			continue
+		}
+		if !bare || m.File == file || (file == "" && hasQuant(m.File, defaultQuant)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// defaultFile returns the file llama-server loads for a bare repo: the one
+// its "latest" manifest names, or "" when there is no manifest yet, in which
+// case the Hub would pick the defaultQuant file.
+func defaultFile(cacheDir, repo string) string {
+	user, name, _ := strings.Cut(repo, "/")
+	data, err := os.ReadFile(filepath.Join(cacheDir, "manifest="+user+"="+name+"=latest.json"))
+	if err != nil {
+		return ""
+	}
+	var manifest struct {
+		GGUFFile struct {
+			RFilename string `json:"rfilename"`
+		} `json:"ggufFile"`
+	}
+	if json.Unmarshal(data, &manifest) != nil {
+		return ""
+	}
+	return path.Base(manifest.GGUFFile.RFilename)
+}
+
+// ListCachedModels returns every GGUF file in the cache, sorted by repo and file.
+func ListCachedModels() ([]CachedModel, error) {
+	cacheDir, err := getCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return listCachedModels(cacheDir)
+}
+
+// FindCachedModels returns the cached files of repo, or only those of one
+// quantization for repo:quant.
+func FindCachedModels(repo string) ([]CachedModel, error) {
+	ref, err := parseRepoRef(repo)
+	if err != nil {
+		return nil, err
+	}
+	models, err := ListCachedModels()
+	if err != nil {
+		return nil, err
+	}
+	return modelsOf(models, ref), nil
+}
+
+// parseRepoRef is ParseModelRef for the cache functions, which have nothing
+// to do with local files.
+func parseRepoRef(repo string) (ModelRef, error) {
+	ref, err := ParseModelRef(repo)
+	if err != nil {
+		return ModelRef{}, err
+	}
+	if ref.Local() {
+		return ModelRef{}, fmt.Errorf("%s is a local file, not a cached repo", ref.Path)
+	}
+	return ref, nil
+}
+
+func modelsOf(models []CachedModel, ref ModelRef) []CachedModel {
+	var matched []CachedModel
+	prefix := repoPrefix(ref.Repo)
+	for _, m := range models {
+		if m.Repo != ref.Repo && !strings.HasPrefix(filepath.Base(m.Path), prefix) {
+			continue
+		}
+		if ref.Quant != "" && !hasQuant(m.File, ref.Quant) {
+			continue
+		}
+		matched = append(matched, m)
+	}
+	return matched
+}
+
+// hasQuant reports whether file is of quantization quant, ignoring case like
+// llama.cpp does. The tag must be a whole part of the name: Q4_K matches
+// model-Q4_K.gguf but not model-Q4_K_M.gguf, and IQ2_XXS matches
+// model-UD-IQ2_XXS.gguf.
+func hasQuant(file, quant string) bool {
+	re, err := regexp.Compile(`(?i)(?:^|[-._])` + regexp.QuoteMeta(quant) + `(?:[-.]|$)`)
+	return err == nil && re.MatchString(file)
+}
+
+func listCachedModels(cacheDir string) ([]CachedModel, error) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil // Cache directory doesn't exist, so nothing is cached
+		}
+		return nil, err
+	}
+
+	repos := manifestRepos(entries)
+	var models []CachedModel
+	for _, entry := range entries {
+		name := entry.Name()
+		downloading := strings.HasSuffix(name, ".gguf"+partialFileSuffix)
+		if entry.IsDir() || !(strings.HasSuffix(name, ".gguf") || downloading) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed while we were looking
+		}
+		repo, file := splitCachedName(strings.TrimSuffix(name, partialFileSuffix), repos)
+		models = append(models, CachedModel{
+			Repo:         repo,
+			File:         file,
+			Quantization: quantization(file),
+			Path:         filepath.Join(cacheDir, name),
+			Size:         info.Size(),
+			ModTime:      info.ModTime(),
+			Downloading:  downloading,
+		})
+	}
+	sort.Slice(models, func(i, j int) bool {
+		if models[i].Repo != models[j].Repo {
+			return models[i].Repo < models[j].Repo
+		}
+		return models[i].File < models[j].File
+	})
+	return models, nil
+}
+
+// manifestRepos collects the repos named by llama.cpp's manifest files
+// (manifest=<user>=<repo>=<tag>.json), which tell us exactly where the repo
+// part of a cached file name ends.
+func manifestRepos(entries []os.DirEntry) []string {
+	var repos []string
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), "manifest=")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(name, ".json"), "=")
+		if len(parts) >= 2 {
+			repos = append(repos, parts[0]+"/"+parts[1])
+		}
+	}
+	// longest first, so "a/b-GGUF" doesn't claim files of "a/b-GGUF_x"
+	sort.Slice(repos, func(i, j int) bool { return len(repos[i]) > len(repos[j]) })
+	return repos
+}
+
+// splitCachedName recovers the repo and file from a cached file name. Without
+// a manifest it guesses: the repo name usually ends in "GGUF", otherwise the
+// user and repo are taken to be the first two underscore-separated fields.
+func splitCachedName(name string, repos []string) (repo, file string) {
+	for _, r := range repos {
+		if f, ok := strings.CutPrefix(name, repoPrefix(r)); ok {
+			return r, f
+		}
+	}
+	if i := strings.Index(strings.ToUpper(name), "GGUF_"); i >= 0 {
+		if user, rest, ok := strings.Cut(name[:i+len("GGUF")], "_"); ok {
+			return user + "/" + rest, name[i+len("GGUF_"):]
+		}
+	}
+	parts := strings.SplitN(name, "_", 3)
+	if len(parts) < 3 {
+		return "", name
+	}
+	return parts[0] + "/" + parts[1], parts[2]
+}
+
+var quantRe = regexp.MustCompile(`(?i)\b(?:UD-)?(?:I?Q\d+(?:_[A-Z0-9]+)*|BF16|F16|F32)\b`)
+
+// quantization extracts the quantization type from a GGUF file name.
+func quantization(file string) string {
+	base := strings.TrimSuffix(file, ".gguf")
+	matches := quantRe.FindAllString(base, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return strings.ToUpper(matches[len(matches)-1])
+}
+
+// RemoveCachedModel deletes the cached files of repo (or of one quantization
+// for repo:quant), along with llama.cpp's
+// metadata and manifest for it, and returns the paths it removed. Partial
+// downloads that are still being written to are skipped with
+// ErrDownloadActive unless force is set.
+func RemoveCachedModel(repo string, force bool) ([]string, error) {
+	cacheDir, err := getCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return removeCachedModel(cacheDir, repo, force)
+}
+
+func removeCachedModel(cacheDir, repo string, force bool) ([]string, error) {
+	ref, err := parseRepoRef(repo)
+	if err != nil {
+		return nil, err
+	}
+	models, err := listCachedModels(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	var errs []error
+	for _, m := range modelsOf(models, ref) {
+		if m.Active() && !force {
+			errs = append(errs, fmt.Errorf("%s: %w (last written %s ago)", m.File, ErrDownloadActive, time.Since(m.ModTime).Round(time.Second)))
+			continue
+		}
+		if err := os.Remove(m.Path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, m.Path)
+		// per-file metadata (etag, last modified) written next to the GGUF
+		gguf := strings.TrimSuffix(m.Path, partialFileSuffix)
+		for _, meta := range []string{gguf + ".json", gguf + ".etag"} {
+			if os.Remove(meta) == nil {
+				removed = append(removed, meta)
+			}
+		}
+	}
+	if len(errs) == 0 && len(removed) > 0 {
+		// manifests are per tag, so a single quant only takes its own along
+		tag := "*"
+		if ref.Quant != "" {
+			tag = ref.Quant
+		}
+		user, name, _ := strings.Cut(ref.Repo, "/")
+		manifests, _ := filepath.Glob(filepath.Join(cacheDir, "manifest="+user+"="+name+"="+tag+".json"))
+		for _, manifest := range manifests {
+			if os.Remove(manifest) == nil {
+				removed = append(removed, manifest)
+			}
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// FormatBytes renders n as a human readable size, e.g. "1.2 GiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
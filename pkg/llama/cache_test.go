@@ -0,0 +1,135 @@
+package llama
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCacheFiles(t *testing.T, dir string, files map[string]int) {
+	t.Helper()
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListCachedModels(t *testing.T) {
+	dir := t.TempDir()
+	writeCacheFiles(t, dir, map[string]int{
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K_M.gguf":            10,
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K_M.gguf.json":       1,
+		"manifest=unsloth=gemma-3-1b-it-GGUF=latest.json":                 1,
+		"my_user_my_model_model-UD-IQ2_XXS.gguf":                          20,
+		"manifest=my_user=my_model=latest.json":                           1,
+		"unsloth_Qwen3-1.7B-GGUF_Qwen3-1.7B-BF16.gguf.downloadInProgress": 30,
+		"ggml-org_SmolLM3-3B-GGUF_SmolLM3-Q8_0.gguf":                      40,
+		"not-a-model.txt": 1,
+	})
+
+	models, err := listCachedModels(dir)
+	if err != nil {
+		t.Fatalf("listCachedModels: %v", err)
+	}
+	want := []CachedModel{
+		{Repo: "ggml-org/SmolLM3-3B-GGUF", File: "SmolLM3-Q8_0.gguf", Quantization: "Q8_0", Size: 40},
+		{Repo: "my_user/my_model", File: "model-UD-IQ2_XXS.gguf", Quantization: "UD-IQ2_XXS", Size: 20},
+		{Repo: "unsloth/Qwen3-1.7B-GGUF", File: "Qwen3-1.7B-BF16.gguf", Quantization: "BF16", Size: 30, Downloading: true},
+		{Repo: "unsloth/gemma-3-1b-it-GGUF", File: "gemma-3-1b-it-Q4_K_M.gguf", Quantization: "Q4_K_M", Size: 10},
+	}
+	if len(models) != len(want) {
+		t.Fatalf("got %d models: %+v", len(models), models)
+	}
+	for i, w := range want {
+		m := models[i]
+		if m.Repo != w.Repo || m.File != w.File || m.Quantization != w.Quantization || m.Size != w.Size || m.Downloading != w.Downloading {
+			t.Errorf("model %d = %+v, want %+v", i, m, w)
+		}
+	}
+}
+
+func TestRemoveCachedModel(t *testing.T) {
+	dir := t.TempDir()
+	writeCacheFiles(t, dir, map[string]int{
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K_M.gguf":                  1,
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K_M.gguf.json":             1,
+		"manifest=unsloth=gemma-3-1b-it-GGUF=latest.json":                       1,
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q8_0.gguf.downloadInProgress": 1,
+		"unsloth_Qwen3-1.7B-GGUF_Qwen3-1.7B-Q4_K_M.gguf":                        1,
+	})
+
+	// the partial file was just written, so it counts as an active download
+	removed, err := removeCachedModel(dir, "unsloth/gemma-3-1b-it-GGUF", false)
+	if !errors.Is(err, ErrDownloadActive) {
+		t.Fatalf("err = %v, want ErrDownloadActive", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("removed %v, want the complete GGUF and its metadata", removed)
+	}
+
+	old := time.Now().Add(-2 * partialGracePeriod)
+	partial := filepath.Join(dir, "unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q8_0.gguf.downloadInProgress")
+	if err := os.Chtimes(partial, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := removeCachedModel(dir, "unsloth/gemma-3-1b-it-GGUF", false); err != nil {
+		t.Fatalf("removing a stale partial: %v", err)
+	}
+
+	left, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(left) != 1 || filepath.Base(left[0]) != "unsloth_Qwen3-1.7B-GGUF_Qwen3-1.7B-Q4_K_M.gguf" {
+		t.Errorf("left behind %v, want only the other repo's file", left)
+	}
+}
+
+func TestRemoveCachedQuant(t *testing.T) {
+	dir := t.TempDir()
+	writeCacheFiles(t, dir, map[string]int{
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K.gguf":   1,
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K_M.gguf": 1,
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K_S.gguf": 1,
+	})
+
+	removed, err := removeCachedModel(dir, "unsloth/gemma-3-1b-it-GGUF:q4_k", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K.gguf" {
+		t.Errorf("removed %v, want only the Q4_K file", removed)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "*")); len(left) != 2 {
+		t.Errorf("left behind %v, want the Q4_K_M and Q4_K_S files", left)
+	}
+}
+
+func TestHasQuant(t *testing.T) {
+	tests := []struct {
+		file, quant string
+		want        bool
+	}{
+		{"model-Q4_K_M.gguf", "Q4_K_M", true},
+		{"model-Q4_K_M.gguf", "q4_k_m", true},
+		{"model-Q4_K_M.gguf", "Q4_K", false},
+		{"model-Q4_K_M-00001-of-00002.gguf", "Q4_K_M", true},
+		{"model-UD-IQ2_XXS.gguf", "IQ2_XXS", true},
+		{"model-UD-IQ2_XXS.gguf", "UD-IQ2_XXS", true},
+		{"model-IQ2_XXS.gguf", "Q2_XXS", false},
+		{"model.Q8_0.gguf", "Q8_0", true},
+	}
+	for _, tt := range tests {
+		if got := hasQuant(tt.file, tt.quant); got != tt.want {
+			t.Errorf("hasQuant(%q, %q) = %v, want %v", tt.file, tt.quant, got, tt.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{512: "512 B", 1536: "1.5 KiB", 806 << 20: "806.0 MiB", 3 << 30: "3.0 GiB"} {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
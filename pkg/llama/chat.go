@@ -0,0 +1,162 @@
+package llama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type ChatMessage struct {
+	Role    string `json:"role"` // "system", "user" or "assistant"
+	Content string `json:"content"`
+}
+
+// ChatReq is a request to llama-server's OpenAI-compatible
+// /v1/chat/completions endpoint, which applies the model's chat template.
+type ChatReq struct {
+	Messages []ChatMessage `json:"messages"`
+	Sampling
+	Stream         bool            `json:"stream"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	CachePrompt    bool            `json:"cache_prompt"`
+	IDSlot         *int            `json:"id_slot,omitempty"`
+	Affinity       string          `json:"-"`                  // see InferenceReq.Affinity
+	LoRA           string          `json:"-"`                  // see InferenceReq.LoRA
+	Logprobs       bool            `json:"logprobs,omitempty"` // see InferenceResp.Probs
+	Grammar        string          `json:"grammar,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat constrains a chat response, see JSONSchemaFormat.
+type ResponseFormat struct {
+	Type       string          `json:"type"`
+	JSONSchema *ResponseSchema `json:"json_schema,omitempty"`
+}
+
+type ResponseSchema struct {
+	Schema json.RawMessage `json:"schema"`
+}
+
+// JSONSchemaFormat returns a ResponseFormat forcing the reply to match schema.
+func JSONSchemaFormat(schema json.RawMessage) *ResponseFormat {
+	return &ResponseFormat{Type: "json_schema", JSONSchema: &ResponseSchema{Schema: schema}}
+}
+
+// chatChunk is one streamed chat.completion.chunk.
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+		} `json:"delta"`
+		Logprobs *struct {
+			Content []tokenProb `json:"content"`
+		} `json:"logprobs"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		CompletionTokens int `json:"completion_tokens"`
+		PromptTokens     int `json:"prompt_tokens"`
+	} `json:"usage"`
+	Timings *Timings     `json:"timings"`
+	Error   *ServerError `json:"error"`
+}
+
+// addTo merges the usage and timings carried by c into r.
+func (c *chatChunk) addTo(r *Result) {
+	if c.Usage != nil {
+		r.TokensPredicted = c.Usage.CompletionTokens
+		r.TokensEvaluated = c.Usage.PromptTokens
+	}
+	if c.Timings != nil {
+		r.Timings = *c.Timings
+	}
+}
+
+// stopTypes maps OpenAI finish reasons onto llama-server's stop types.
+var stopTypes = map[string]string{"stop": "eos", "length": "limit"}
+
+// Chat streams the assistant's reply to req. Deltas are delivered as
+// InferenceResp events with the same error semantics as Inference. The final
+// event has Stop set and carries the Result, which llama-server may spread
+// over the chunks following the finish reason.
+func (s *Server) Chat(ctx context.Context, req ChatReq) (<-chan InferenceResp, error) {
+	req.Stream = true
+
+	release, err := s.useLoRA(ctx, req.LoRA)
+	if err != nil {
+		return nil, err
+	}
+	first := firstToken{start: time.Now()}
+	resp, err := s.postStream(ctx, "/v1/chat/completions", req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	respChan := make(chan InferenceResp, 100)
+
+	go func() {
+		defer resp.Body.Close()
+		defer close(respChan)
+		defer release()
+
+		var splitter reasoningSplitter // for servers that leave the tags in content
+		var final *InferenceResp       // held back until usage and timings are in
+		err := readEvents(resp.Body, func(data []byte) (bool, error) {
+			var chunk chatChunk
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				return true, &MalformedEventError{Data: string(data), Err: err}
+			}
+			if chunk.Error != nil {
+				return true, chunk.Error
+			}
+			if final != nil {
+				chunk.addTo(final.Result)
+				return false, nil
+			}
+			if len(chunk.Choices) == 0 {
+				return false, nil
+			}
+			choice := chunk.Choices[0]
+			event := InferenceResp{
+				Content:   choice.Delta.Content,
+				Reasoning: choice.Delta.ReasoningContent,
+				Stop:      choice.FinishReason != nil,
+			}
+			splitter.apply(&event)
+			if choice.Logprobs != nil {
+				event.setProbs(choice.Logprobs.Content)
+			}
+			first.observe(event)
+			if event.Stop {
+				event.Result = &Result{StopType: stopTypes[*choice.FinishReason], TimeToFirstToken: first.ttft}
+				event.Result.Truncated = event.Result.StopType == "limit"
+				chunk.addTo(event.Result)
+				final = &event
+				return false, nil
+			}
+			if !send(ctx, respChan, event) {
+				return true, ctx.Err()
+			}
+			return false, nil
+		})
+		if final == nil && err == nil {
+			err = ErrIncompleteStream // [DONE] without a finish reason
+		}
+		if final != nil && (err == nil || errors.Is(err, ErrIncompleteStream)) {
+			// the answer is complete even if the [DONE] marker never came
+			send(ctx, respChan, *final)
+			return
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			send(ctx, respChan, InferenceResp{Err: err})
+		}
+	}()
+
+	return respChan, nil
+}
@@ -0,0 +1,65 @@
+package llama
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestChat(t *testing.T) {
+	s, fake := newFakeBackedServer(t, llamatest.Config{Tokens: []string{"I", " went", "."}}, true)
+
+	respChan, err := s.Chat(context.Background(), ChatReq{
+		Messages: []ChatMessage{
+			{Role: "system", Content: "You are an editor."},
+			{Role: "user", Content: "Fix: I has went."},
+		},
+		Sampling:       Sampling{Temp: Float(0.2)},
+		MaxTokens:      16,
+		ResponseFormat: JSONSchemaFormat(EditResultSchema),
+	})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	var content string
+	var stopped bool
+	for resp := range respChan {
+		if resp.Err != nil {
+			t.Fatalf("stream error: %v", resp.Err)
+		}
+		content += resp.Content
+		stopped = resp.Stop
+	}
+	if content != "I went." || !stopped {
+		t.Errorf("got %q (stopped %v), want %q ending with a stop event", content, stopped, "I went.")
+	}
+
+	req := fake.Requests()[0]
+	messages, _ := req["messages"].([]any)
+	if len(messages) != 2 || req["stream"] != true || req["max_tokens"] != 16.0 {
+		t.Errorf("unexpected request body: %v", req)
+	}
+	format, _ := req["response_format"].(map[string]any)
+	if format["type"] != "json_schema" {
+		t.Errorf("response_format = %v", req["response_format"])
+	}
+}
+
+func TestChatErrorEvent(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{Events: []string{
+		`{"choices":[{"index":0,"delta":{"content":"a"},"finish_reason":null}]}`,
+		`{"error":{"code":400,"message":"context overflow","type":"invalid_request_error"}}`,
+	}}, true)
+
+	respChan, err := s.Chat(context.Background(), ChatReq{Messages: []ChatMessage{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	content, err := drain(t, respChan)
+	var serverErr *ServerError
+	if content != "a" || !errors.As(err, &serverErr) || serverErr.Code != 400 {
+		t.Errorf("got %q, %v; want %q and a 400 *ServerError", content, err, "a")
+	}
+}
@@ -0,0 +1,97 @@
+package llama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// Embedder is implemented by backends serving an embedding model.
+type Embedder interface {
+	// Embed returns one vector per text, in order.
+	Embed(ctx context.Context, texts ...string) ([][]float32, error)
+}
+
+var _ Embedder = (*Server)(nil)
+
+// Embed returns the pooled embedding of each text using llama-server's
+// /embedding. The server must run an embedding model with --embeddings
+// (see Options.Embeddings).
+func (s *Server) Embed(ctx context.Context, texts ...string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	var raw json.RawMessage
+	if err := s.call(ctx, http.MethodPost, "/embedding", map[string]any{"content": texts}, &raw); err != nil {
+		return nil, err
+	}
+	vectors, err := parseEmbeddings(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("llama-server returned %d embeddings for %d texts", len(vectors), len(texts))
+	}
+	return vectors, nil
+}
+
+// parseEmbeddings decodes the answers of the different llama-server
+// versions: a list of {index, embedding} where embedding is a vector or, in
+// newer versions, a list holding the pooled vector; or a single object for a
+// single text.
+func parseEmbeddings(raw json.RawMessage) ([][]float32, error) {
+	type item struct {
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"`
+	}
+	var items []item
+	if err := json.Unmarshal(raw, &items); err != nil {
+		var single item
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, fmt.Errorf("failed to decode /embedding response: %w", err)
+		}
+		items = []item{single}
+	}
+
+	vectors := make([][]float32, len(items))
+	for i, it := range items {
+		if it.Index < 0 || it.Index >= len(items) {
+			return nil, fmt.Errorf("embedding index %d out of range", it.Index)
+		}
+		var vec []float32
+		if err := json.Unmarshal(it.Embedding, &vec); err != nil {
+			var pooled [][]float32
+			if err := json.Unmarshal(it.Embedding, &pooled); err != nil {
+				return nil, fmt.Errorf("failed to decode embedding %d: %w", i, err)
+			}
+			if len(pooled) != 1 {
+				// one vector per token: the model was loaded with --pooling none
+				return nil, errors.New("llama-server returned unpooled embeddings, the model needs a pooling type")
+			}
+			vec = pooled[0]
+		}
+		vectors[it.Index] = vec
+	}
+	return vectors, nil
+}
+
+// CosineSimilarity returns the cosine of the angle between a and b, from -1
+// to 1, or 0 if either is empty, zero or they differ in length.
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
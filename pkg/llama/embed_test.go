@@ -0,0 +1,78 @@
+package llama
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestEmbed(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{}, true)
+	vectors, err := s.Embed(context.Background(), "the cat sat", "The cat sat.", "dogs bark loudly")
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != 3 {
+		t.Fatalf("got %d vectors, want 3", len(vectors))
+	}
+	if sim := CosineSimilarity(vectors[0], vectors[1]); math.Abs(sim-1) > 1e-6 {
+		t.Errorf("similarity of the same words = %v, want 1", sim)
+	}
+	if sim := CosineSimilarity(vectors[0], vectors[2]); sim > 0.5 {
+		t.Errorf("similarity of different words = %v, want low", sim)
+	}
+}
+
+func TestParseEmbeddings(t *testing.T) {
+	tests := []struct {
+		name, raw string
+		want      [][]float32
+		wantErr   bool
+	}{
+		{"pooled list", `[{"index":1,"embedding":[[3,4]]},{"index":0,"embedding":[[1,2]]}]`, [][]float32{{1, 2}, {3, 4}}, false},
+		{"flat list", `[{"index":0,"embedding":[1,2]}]`, [][]float32{{1, 2}}, false},
+		{"single object", `{"embedding":[1,2]}`, [][]float32{{1, 2}}, false},
+		{"unpooled", `[{"index":0,"embedding":[[1,2],[3,4]]}]`, nil, true},
+		{"bad index", `[{"index":5,"embedding":[1]}]`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseEmbeddings(json.RawMessage(tt.raw))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if CosineSimilarity(got[i], tt.want[i]) < 0.9999 || len(got[i]) != len(tt.want[i]) || got[i][0] != tt.want[i][0] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b []float32
+		want float64
+	}{
+		{[]float32{1, 0}, []float32{1, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 1}, 0},
+		{[]float32{1, 0}, []float32{-1, 0}, -1},
+		{[]float32{0, 0}, []float32{1, 0}, 0},
+		{[]float32{1}, []float32{1, 0}, 0},
+	}
+	for _, tt := range tests {
+		if got := CosineSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("CosineSimilarity(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -2,83 +2,291 @@ package llama
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"os"
+	"net/url"
 	"os/exec"
-	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+)
+
+// Backend is an inference runtime that nomodit can drive. Server, which spawns
+// a local llama-server process, is the default implementation; callers should
+// depend on Backend so other runtimes and test doubles can be plugged in.
+type Backend interface {
+	// Start launches (or connects to) the runtime. It does not wait for it to be ready.
+	Start() error
+	// StatusUpdates reports startup progress and is closed once the backend is ready
+	// or has failed.
+	StatusUpdates(ctx context.Context) <-chan ServerStatus
+	// Inference streams the completion for req until it finishes, fails (see
+	// InferenceResp.Err) or ctx is cancelled.
+	Inference(ctx context.Context, req InferenceReq) (<-chan InferenceResp, error)
+	// Chat streams the reply to a conversation, formatted with the model's
+	// chat template. Events follow the same rules as Inference.
+	Chat(ctx context.Context, req ChatReq) (<-chan InferenceResp, error)
+	// Stop releases the runtime. It is safe to call on a backend that never started.
+	Stop()
+}
+
+var _ Backend = (*Server)(nil)
 
-	"github.com/spf13/viper"
+// Health polling and shutdown knobs, variables so tests can shorten them.
+var (
+	healthPollInterval = 300 * time.Millisecond
+	cachedStartTimeout = 30 * time.Second
+	downloadTimeout    = 4 * time.Hour
+	stopTimeout        = 5 * time.Second
 )
 
 type ServerStatus struct {
 	Message string
 	IsError bool
+	// Progress is set on statuses reporting a model download.
+	Progress *Progress
+	// Ready is set on the status reporting that the server is healthy.
+	Ready bool
 }
 
+// ErrPortInUse is returned by Start when the requested port is already bound
+// by another process.
+var ErrPortInUse = errors.New("port already in use")
+
 type Server struct {
+	llm           string // repo, repo:quant or local GGUF path, see ParseModelRef
+	opts          Options
 	llamaCmd      *exec.Cmd
 	port          string
 	baseURL       string
-	stderr        io.ReadCloser
+	stderrStatus  chan ServerStatus // statuses parsed from llama-server's stderr
+	exited        chan struct{}     // closed once the spawned process has exited
+	waitErr       error             // exit status, valid after exited is closed
+	stopOnce      sync.Once
 	isModelCached bool
+	download      downloadTracker
+	propsMu       sync.Mutex
+	props         *serverProps // cached by fetchProps
+	remote        bool         // attached to a llama-server nomodit didn't start
+	lora          loraGate
 }
 
 type InferenceReq struct {
-	Prompt      string  `json:"prompt"`
-	Temp        float32 `json:"temp,omitempty"`
-	Stream      bool    `json:"stream,omitempty"`
-	NPredict    int     `json:"n_predict,omitempty"`
-	CachePrompt bool    `json:"cache_prompt"`
+	Prompt string `json:"prompt"`
+	Sampling
+	Stream   bool `json:"stream,omitempty"`
+	NPredict int  `json:"n_predict,omitempty"`
+	// CachePrompt lets llama-server reuse the KV cache of the slot's previous
+	// prompt for the prefix this one shares with it.
+	CachePrompt bool `json:"cache_prompt"`
+	// IDSlot pins the request to one slot, nil for any.
+	IDSlot *int `json:"id_slot,omitempty"`
+	// Affinity groups requests whose prompts start alike, e.g. with the same
+	// instruction. A Scheduler sends them to the same slot so its cache holds
+	// that prefix, and other requests elsewhere when it can.
+	Affinity string `json:"-"`
+	// LoRA is the name of the adapter to apply (see Options.LoRA), "" for
+	// the bare model.
+	LoRA string `json:"-"`
+	// NProbs > 0 asks for the probability of every generated token, see
+	// InferenceResp.Probs.
+	NProbs int `json:"n_probs,omitempty"`
+	// Grammar (GBNF) or JSONSchema constrain what the model may output, see
+	// EditResultSchema.
+	Grammar    string          `json:"grammar,omitempty"`
+	JSONSchema json.RawMessage `json:"json_schema,omitempty"`
+}
+
+// Sampling holds llama.cpp's sampling parameters. Nil values are left out of
+// the request, so llama-server's own defaults apply to anything unset, while
+// an explicit zero such as a temperature of 0 (greedy decoding) is sent.
+type Sampling struct {
+	Temp            *float32 `json:"temperature,omitempty"`
+	TopK            *int     `json:"top_k,omitempty"`
+	TopP            *float32 `json:"top_p,omitempty"`
+	MinP            *float32 `json:"min_p,omitempty"`
+	TypicalP        *float32 `json:"typical_p,omitempty"`
+	RepeatPenalty   *float32 `json:"repeat_penalty,omitempty"`
+	PresencePenalty *float32 `json:"presence_penalty,omitempty"`
+	Seed            *int     `json:"seed,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	NKeep           *int     `json:"n_keep,omitempty"`
 }
 
+// Float returns a pointer to v, for the fields of Sampling.
+func Float(v float32) *float32 { return &v }
+
+// Int returns a pointer to v, for the fields of Sampling.
+func Int(v int) *int { return &v }
+
 type InferenceResp struct {
 	Content string `json:"content"`
-	Stop    bool   `json:"stop"`
+	// Reasoning is the part of this chunk that belongs to the model's reasoning
+	// (<think>/<reasoning> blocks or reasoning_content deltas) rather than to
+	// its answer. It is never included in Content.
+	Reasoning string `json:"-"`
+	// Probs are the tokens of this chunk with their probabilities, if the
+	// request asked for them. They may run across the answer and reasoning
+	// of a chunk holding both.
+	Probs []TokenProb `json:"-"`
+	Stop  bool        `json:"stop"`
+	// Result is set on the final (Stop) event.
+	Result *Result `json:"-"`
+	// Err is set on the last event of a stream that ended abnormally.
+	Err error `json:"-"`
+	// Retry is set on the first event of a stream a Supervisor re-sent after
+	// a crash; whatever was received before it should be discarded.
+	Retry bool `json:"-"`
+}
+
+// NewServer returns a Server that will spawn llama-server for llm on port once
+// Start is called. An empty or "0" port lets Start pick a free one. llm is a
+// Hugging Face repo, repo:quant or the path of a local GGUF file.
+func NewServer(llm string, port string) *Server {
+	return NewServerWithOptions(llm, port, Options{})
+}
+
+// NewServerWithOptions is NewServer with launch options such as the context
+// size or a draft model. They are validated by Start.
+func NewServerWithOptions(llm string, port string, opts Options) *Server {
+	return &Server{
+		llm:  llm,
+		port: port,
+		opts: opts,
+	}
+}
+
+// NewRemoteServer returns a Server attached to an already running llama-server
+// at baseURL. Start only validates the URL and Stop leaves the process alone.
+func NewRemoteServer(baseURL string) *Server {
+	return &Server{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		isModelCached: true, // whoever runs it has the model loaded or loading
+		remote:        true,
+	}
 }
 
+// StartServer is a shorthand for NewServer followed by Start.
 func StartServer(llm string, port string) (*Server, error) {
-	llamaCmd, err := exec.LookPath("llama-server")
+	server := NewServer(llm, port)
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func (s *Server) Start() error {
+	if s.remote {
+		u, err := url.Parse(s.baseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid llama-server URL %q", s.baseURL)
+		}
+		s.port = u.Port()
+		return nil
+	}
+
+	port := s.port
+	if port == "" || port == "0" {
+		var err error
+		if port, err = freePort(); err != nil {
+			return fmt.Errorf("failed to find a free port: %w", err)
+		}
+	} else if err := checkPortFree(port); err != nil {
+		return err
+	}
+
+	if err := s.opts.Validate(); err != nil {
+		return err
+	}
+	modelArgs, err := modelArgs(s.llm, s.opts)
 	if err != nil {
-		return nil, fmt.Errorf("llama-server not found: %w", err)
+		return err
+	}
+
+	llamaCmd, err := exec.LookPath(s.opts.binary())
+	if err != nil {
+		return fmt.Errorf("llama-server not found: %w", err)
 	}
 
 	cacheDir, err := getCacheDir()
-	var isCached bool
 	if err == nil {
 		// we can ignore the error here, if we can't check, we'll just assume it's not cached
-		isCached, _ = isModelCached(llm, cacheDir)
+		s.isModelCached, _ = isModelCached(s.llm, cacheDir)
+		if s.isModelCached && s.opts.Draft != "" {
+			s.isModelCached, _ = isModelCached(s.opts.Draft, cacheDir)
+		}
 	}
 
-	args := []string{"-hf", llm, "--port", port}
+	// bind to loopback only so health checks can't end up talking to something
+	// listening on another interface
+	args := append(modelArgs, "--host", "127.0.0.1", "--port", port)
+	args = append(args, s.opts.args()...)
 	cmd := exec.Command(llamaCmd, args...)
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	if s.opts.Log != nil {
+		fmt.Fprintf(s.opts.Log, "--- %s launching %s ---\n", time.Now().Format(time.RFC3339), commandLine(cmd.Args))
 	}
-
-	server := &Server{
-		llamaCmd:      cmd,
-		port:          port,
-		baseURL:       fmt.Sprintf("http://localhost:%s", port),
-		stderr:        stderr,
-		isModelCached: isCached,
+	if err := s.launch(cmd); err != nil {
+		return err
 	}
+	s.port = port
+	s.baseURL = "http://127.0.0.1:" + port
+
+	return nil
+}
+
+// launch starts cmd in its own process group and reaps it in the background.
+func (s *Server) launch(cmd *exec.Cmd) error {
+	// an io.Pipe rather than StderrPipe so Wait can run concurrently with the reader
+	stderr, stderrWriter := io.Pipe()
+	cmd.Stderr = stderrWriter
+	// newer llama.cpp prints its download progress bar on stdout
+	cmd.Stdout = stderrWriter
+	setProcessGroup(cmd)
 
 	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start llama-server: %w", err)
+		return fmt.Errorf("failed to start llama-server: %w", err)
 	}
+	s.llamaCmd = cmd
+	s.exited = make(chan struct{})
+	s.watchStderr(stderr)
 
-	return server, nil
+	go func() {
+		s.waitErr = cmd.Wait()
+		if s.opts.Log != nil {
+			fmt.Fprintf(s.opts.Log, "--- %s llama-server exited: %v ---\n", time.Now().Format(time.RFC3339), s.waitErr)
+		}
+		stderrWriter.Close()
+		close(s.exited)
+	}()
+	return nil
+}
+
+// Port returns the port llama-server listens on, once Start has picked it.
+func (s *Server) Port() string {
+	return s.port
+}
+
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+func checkPortFree(port string) error {
+	l, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPortInUse, port)
+	}
+	return l.Close()
 }
 
 func (s *Server) StatusUpdates(ctx context.Context) <-chan ServerStatus {
@@ -94,46 +302,97 @@ func (s *Server) StatusUpdates(ctx context.Context) <-chan ServerStatus {
 		if !s.isModelCached {
 			statusChan <- ServerStatus{Message: "Model not cached, download required..."}
 		}
-		statusChan <- ServerStatus{Message: "Starting llama-server"}
+		if s.remote {
+			statusChan <- ServerStatus{Message: "Connecting to llama-server at " + s.baseURL}
+		} else {
+			statusChan <- ServerStatus{Message: "Starting llama-server"}
+		}
 
+		errCtx, cancel := context.WithCancel(ctx)
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			s.monitorErrors(statusChan)
+			s.monitorErrors(errCtx, statusChan)
 		}()
+		if !s.isModelCached {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.monitorDownload(errCtx, statusChan)
+			}()
+		}
 
 		s.monitorHealth(ctx, statusChan)
-		s.stderr.Close()
+		cancel()
 	}()
 	return statusChan
 }
 
-func (s *Server) monitorErrors(statusChan chan<- ServerStatus) {
-	scanner := bufio.NewScanner(s.stderr)
-	for scanner.Scan() {
-		line := scanner.Text()
-		switch {
-		case strings.Contains(line, "trying to download model"):
-			llm := viper.GetString("llm")
-			statusChan <- ServerStatus{Message: fmt.Sprintf("Downloading model '%s', this can take a while...", llm), IsError: false}
-		case strings.Contains(line, "error: model is private or does not exist; if you are accessing a gated model, please provide a valid HF token"):
-			statusChan <- ServerStatus{Message: "Model is private or does not exist; try using a different model", IsError: true}
-		case strings.Contains(line, "error:"):
-			statusChan <- ServerStatus{Message: line, IsError: true}
+// watchStderr drains llama-server's stderr for the lifetime of the process,
+// turning the lines we care about into statuses for monitorErrors. It never
+// stops reading, otherwise llama-server would block (or die) writing its logs.
+func (s *Server) watchStderr(stderr io.Reader) {
+	s.stderrStatus = make(chan ServerStatus, 32)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		scanner.Split(scanOutputLines)
+		for scanner.Scan() {
+			if s.download.observe(scanner.Text()) {
+				continue
+			}
+			if s.opts.Log != nil {
+				fmt.Fprintln(s.opts.Log, scanner.Text())
+			}
+			status, ok := s.parseStderr(scanner.Text())
+			if !ok {
+				continue
+			}
+			select {
+			case s.stderrStatus <- status:
+			default: // nobody is listening anymore
+			}
+		}
+		io.Copy(io.Discard, stderr) // only reached on an overlong line
+	}()
+}
+
+func (s *Server) parseStderr(line string) (ServerStatus, bool) {
+	switch {
+	case strings.Contains(line, "trying to download model"):
+		return ServerStatus{Message: fmt.Sprintf("Downloading model '%s', this can take a while...", s.llm), IsError: false}, true
+	case strings.Contains(line, "error: model is private or does not exist; if you are accessing a gated model, please provide a valid HF token"):
+		return ServerStatus{Message: "Model is private or does not exist; try using a different model", IsError: true}, true
+	case strings.Contains(line, "error:"):
+		return ServerStatus{Message: line, IsError: true}, true
+	}
+	return ServerStatus{}, false
+}
+
+func (s *Server) monitorErrors(ctx context.Context, statusChan chan<- ServerStatus) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case status := <-s.stderrStatus:
+			select {
+			case statusChan <- status:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
 
 func (s *Server) monitorHealth(ctx context.Context, statusChan chan<- ServerStatus) {
 	healthURL := s.baseURL + "/health"
-	ticker := time.NewTicker(300 * time.Millisecond)
+	ticker := time.NewTicker(healthPollInterval)
 	defer ticker.Stop()
 
 	var timeout time.Duration
 	if s.isModelCached {
-		timeout = 30 * time.Second
+		timeout = cachedStartTimeout
 	} else {
-		timeout = 4 * time.Hour
+		timeout = downloadTimeout
 	}
 	timeoutChan := time.After(timeout)
 
@@ -144,6 +403,9 @@ func (s *Server) monitorHealth(ctx context.Context, statusChan chan<- ServerStat
 		case <-timeoutChan:
 			statusChan <- ServerStatus{Message: "Server startup timed out", IsError: true}
 			return
+		case <-s.exited:
+			statusChan <- ServerStatus{Message: fmt.Sprintf("llama-server exited unexpectedly: %v", s.waitErr), IsError: true}
+			return
 		case <-ticker.C:
 			resp, err := http.Get(healthURL)
 			if err != nil {
@@ -152,7 +414,7 @@ func (s *Server) monitorHealth(ctx context.Context, statusChan chan<- ServerStat
 			resp.Body.Close()
 			switch resp.StatusCode {
 			case 200:
-				statusChan <- ServerStatus{Message: "Server is ready", IsError: false}
+				statusChan <- ServerStatus{Message: "Server is ready", Ready: true}
 				return
 			case 503:
 				statusChan <- ServerStatus{Message: "Loading model", IsError: false}
@@ -163,37 +425,60 @@ func (s *Server) monitorHealth(ctx context.Context, statusChan chan<- ServerStat
 	}
 }
 
+// Stop shuts down the llama-server process group started by Start: SIGTERM
+// first, then SIGKILL if it hasn't exited within stopTimeout. It returns once
+// the process has been reaped. Attached servers are never touched.
 func (s *Server) Stop() {
-	if s.llamaCmd != nil && s.llamaCmd.Process != nil {
-		s.llamaCmd.Process.Kill()
+	if s.remote || s.llamaCmd == nil || s.llamaCmd.Process == nil {
+		return
+	}
+	s.stopOnce.Do(func() {
+		if err := terminate(s.llamaCmd.Process); err == nil {
+			select {
+			case <-s.exited:
+				return
+			case <-time.After(stopTimeout):
+			}
+		}
+		kill(s.llamaCmd.Process)
+		<-s.exited
+	})
+}
+
+// CommandLine returns the command llama-server was launched with, quoted for
+// a shell, or "" if Start hasn't launched it.
+func (s *Server) CommandLine() string {
+	if s.llamaCmd == nil {
+		return ""
 	}
+	return commandLine(s.llamaCmd.Args)
 }
 
-func (s *Server) Inference(req InferenceReq) (<-chan InferenceResp, error) {
+// Wait blocks until the spawned llama-server exits and returns its exit status.
+// It returns nil straight away if no process was started.
+func (s *Server) Wait() error {
+	if s.exited == nil {
+		return nil
+	}
+	<-s.exited
+	return s.waitErr
+}
 
+// Inference streams the completion for req. Cancelling ctx aborts the
+// generation. A stream that fails ends with an event whose Err is set: a
+// *ServerError, a *MalformedEventError, ErrIncompleteStream or ctx's error.
+func (s *Server) Inference(ctx context.Context, req InferenceReq) (<-chan InferenceResp, error) {
 	req.Stream = true
-	req.CachePrompt = false
 
-	jsonReq, err := json.Marshal(req)
+	release, err := s.useLoRA(ctx, req.LoRA)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	client := &http.Client{
-		Timeout: 3 * time.Minute,
+		return nil, err
 	}
-
-	resp, err := client.Post(
-		s.baseURL+"/completion",
-		"application/json",
-		bytes.NewBuffer(jsonReq),
-	)
+	first := firstToken{start: time.Now()}
+	resp, err := s.postStream(ctx, "/completion", req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+		release()
+		return nil, err
 	}
 
 	respChan := make(chan InferenceResp, 100)
@@ -201,86 +486,44 @@ func (s *Server) Inference(req InferenceReq) (<-chan InferenceResp, error) {
 	go func() {
 		defer resp.Body.Close()
 		defer close(respChan)
-
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			line := scanner.Text()
-
-			if line == "" || !strings.HasPrefix(line, "data: ") {
-				continue
+		defer release()
+
+		var splitter reasoningSplitter
+		err := readEvents(resp.Body, func(data []byte) (bool, error) {
+			var event struct {
+				InferenceResp
+				Probs []tokenProb  `json:"completion_probabilities"`
+				Error *ServerError `json:"error"`
 			}
-			data := strings.TrimPrefix(line, "data: ")
-
-			var inferenceResp InferenceResp // new struct on every req so as to allow handling of data before replacing
-			if err := json.Unmarshal([]byte(data), &inferenceResp); err != nil {
-				continue
+			if err := json.Unmarshal(data, &event); err != nil {
+				return true, &MalformedEventError{Data: string(data), Err: err}
 			}
-
-			respChan <- inferenceResp
-
-			if inferenceResp.Stop {
-				break
+			if event.Error != nil {
+				return true, event.Error
 			}
-		}
-	}()
-
-	return respChan, nil
-}
-
-func getCacheDir() (string, error) {
-	if cacheDir := os.Getenv("LLAMA_CACHE"); cacheDir != "" {
-		return cacheDir, nil
-	}
-
-	var baseDir string
-
-	switch runtime.GOOS {
-	case "darwin":
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		baseDir = filepath.Join(home, "Library", "Caches")
-	case "windows":
-		baseDir = os.Getenv("LOCALAPPDATA")
-		if baseDir == "" {
-			return "", fmt.Errorf("LOCALAPPDATA environment variable not set")
-		}
-	default: // assuming linux-like
-		if cacheHome := os.Getenv("XDG_CACHE_HOME"); cacheHome != "" {
-			baseDir = cacheHome
-		} else {
-			home, err := os.UserHomeDir()
-			if err != nil {
-				return "", err
+			splitter.apply(&event.InferenceResp)
+			event.setProbs(event.Probs)
+			first.observe(event.InferenceResp)
+			if event.Stop {
+				result := &Result{}
+				if err := json.Unmarshal(data, result); err != nil {
+					return true, &MalformedEventError{Data: string(data), Err: err}
+				}
+				result.TimeToFirstToken = first.ttft
+				event.Result = result
 			}
-			baseDir = filepath.Join(home, ".cache")
-		}
-	}
-
-	return filepath.Join(baseDir, "llama.cpp"), nil
-}
-
-func isModelCached(llm string, cacheDir string) (bool, error) {
-	// e.g. unsloth/Qwen3-1.7B-GGUF -> unsloth_Qwen3-1.7B-GGUF
-	modelPrefix := strings.ReplaceAll(llm, "/", "_")
-
-	files, err := os.ReadDir(cacheDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil // Cache directory doesn't exist, so model isn't cached
-		}
-		return false, err
-	}
-
-	for _, file := range files {
-		if !file.IsDir() && strings.HasPrefix(file.Name(), modelPrefix) {
-			if strings.HasSuffix(file.Name(), ".gguf.downloadInProgress") {
-				return false, nil
+			if !send(ctx, respChan, event.InferenceResp) {
+				return true, ctx.Err()
+			}
+			return event.Stop, nil
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
 			}
-			return true, nil
+			send(ctx, respChan, InferenceResp{Err: err})
 		}
-	}
+	}()
 
-	return false, nil
+	return respChan, nil
 }
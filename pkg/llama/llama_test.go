@@ -1,12 +1,35 @@
 package llama
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
 )
 
+func init() {
+	healthPollInterval = 10 * time.Millisecond
+	downloadPollInterval = 10 * time.Millisecond
+}
+
+// TestLlamaServer runs against a real llama-server and downloads a model, so
+// it only runs when the binary is installed and -short isn't set.
 func TestLlamaServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping llama-server integration test in short mode")
+	}
+	if _, err := exec.LookPath("llama-server"); err != nil {
+		t.Skip("llama-server not installed")
+	}
 	fmt.Println("Starting llama server")
 	server, err := StartServer("unsloth/gemma-3-1b-it-GGUF", "8091")
 	if err != nil {
@@ -20,11 +43,11 @@ func TestLlamaServer(t *testing.T) {
 
 	inferenceReq1 := InferenceReq{
 		Prompt:   "what is the capital of france?",
-		Temp:     0.1,
+		Sampling: Sampling{Temp: Float(0.1)},
 		NPredict: 100,
 	}
 
-	inferenceRespChan, err := server.Inference(inferenceReq1)
+	inferenceRespChan, err := server.Inference(context.Background(), inferenceReq1)
 	if err != nil {
 		t.Fatalf("Failed to get inference response: %v", err)
 	}
@@ -36,11 +59,11 @@ func TestLlamaServer(t *testing.T) {
 
 	inferenceReq2 := InferenceReq{
 		Prompt:   "What did i ask you before this?",
-		Temp:     0.1,
+		Sampling: Sampling{Temp: Float(0.1)},
 		NPredict: 100,
 	}
 
-	inferenceRespChan, err = server.Inference(inferenceReq2)
+	inferenceRespChan, err = server.Inference(context.Background(), inferenceReq2)
 	if err != nil {
 		t.Fatalf("Failed to get inference response: %v", err)
 	}
@@ -59,3 +82,442 @@ func checkStatus(statusChan <-chan ServerStatus) {
 		}
 	}
 }
+
+// newFakeBackedServer points a Server at a fake llama-server, as if Start had
+// spawned it.
+func newFakeBackedServer(t *testing.T, cfg llamatest.Config, cached bool) (*Server, *llamatest.Server) {
+	t.Helper()
+	fake := llamatest.NewServer(cfg)
+	t.Cleanup(fake.Close)
+	s := &Server{
+		llm:           "test/model-GGUF",
+		baseURL:       fake.URL,
+		isModelCached: cached,
+	}
+	s.watchStderr(fake.Stderr())
+	return s, fake
+}
+
+func collectStatuses(t *testing.T, s *Server) []ServerStatus {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var statuses []ServerStatus
+	for status := range s.StatusUpdates(ctx) {
+		statuses = append(statuses, status)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("status updates did not finish: %v", statuses)
+	}
+	return statuses
+}
+
+func TestStatusUpdatesReady(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{LoadingPolls: 2}, true)
+
+	statuses := collectStatuses(t, s)
+	if statuses[0].Message != "Starting llama-server" {
+		t.Errorf("first status = %q, want %q", statuses[0].Message, "Starting llama-server")
+	}
+	var loading int
+	for _, status := range statuses {
+		if status.IsError {
+			t.Errorf("unexpected error status: %q", status.Message)
+		}
+		if status.Message == "Loading model" {
+			loading++
+		}
+	}
+	if loading != 2 {
+		t.Errorf("got %d loading statuses, want 2", loading)
+	}
+	if last := statuses[len(statuses)-1]; last.Message != "Server is ready" {
+		t.Errorf("last status = %q, want %q", last.Message, "Server is ready")
+	}
+}
+
+func TestStatusUpdatesStderr(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{
+		Stderr: []string{
+			"common_download_file: trying to download model from https://huggingface.co/...",
+			"load_model: some unrelated log line",
+			"error: model is private or does not exist; if you are accessing a gated model, please provide a valid HF token",
+			"main: error: failed to load model",
+		},
+	}, false)
+
+	statuses := collectStatuses(t, s)
+	want := []ServerStatus{
+		{Message: "Model not cached, download required..."},
+		{Message: "Starting llama-server"},
+		{Message: "Downloading model 'test/model-GGUF', this can take a while..."},
+		{Message: "Model is private or does not exist; try using a different model", IsError: true},
+		{Message: "main: error: failed to load model", IsError: true},
+		{Message: "Server is ready", Ready: true},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("got statuses %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("status %d = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+}
+
+func TestServerLog(t *testing.T) {
+	fake := llamatest.NewServer(llamatest.Config{
+		Stderr: []string{
+			"load_model: loading model 'x.gguf'",
+			"[=====>    ]  50%  (400 MB / 800 MB) ",
+			"main: server is listening on http://127.0.0.1:8080",
+		},
+	})
+	t.Cleanup(fake.Close)
+	var serverLog syncBuffer
+	s := &Server{llm: "test/model-GGUF", baseURL: fake.URL, isModelCached: true, opts: Options{Log: &serverLog}}
+	s.watchStderr(fake.Stderr())
+	collectStatuses(t, s)
+
+	want := "load_model: loading model 'x.gguf'\nmain: server is listening on http://127.0.0.1:8080\n"
+	if got := serverLog.String(); got != want {
+		t.Errorf("server log = %q, want %q", got, want)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for the stderr watcher to write to while
+// a test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStatusUpdatesUnhealthy(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{HealthStatus: 500}, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for status := range s.StatusUpdates(ctx) {
+		if status.IsError {
+			if status.Message != "Server not ready: 500" {
+				t.Errorf("error status = %q, want %q", status.Message, "Server not ready: 500")
+			}
+			return
+		}
+	}
+	t.Fatal("expected an error status")
+}
+
+func TestStatusUpdatesTimeout(t *testing.T) {
+	defer func(d time.Duration) { cachedStartTimeout = d }(cachedStartTimeout)
+	cachedStartTimeout = 50 * time.Millisecond
+
+	s, _ := newFakeBackedServer(t, llamatest.Config{LoadingPolls: 1 << 30}, true)
+
+	statuses := collectStatuses(t, s)
+	last := statuses[len(statuses)-1]
+	if !last.IsError || last.Message != "Server startup timed out" {
+		t.Errorf("last status = %+v, want startup timeout error", last)
+	}
+}
+
+func TestInference(t *testing.T) {
+	s, fake := newFakeBackedServer(t, llamatest.Config{Tokens: []string{"Paris", " is", " the capital."}}, true)
+
+	respChan, err := s.Inference(context.Background(), InferenceReq{
+		Prompt:   "what is the capital of france?",
+		Sampling: Sampling{Temp: Float(0.5), TopK: Int(20), Stop: []string{"\n\n"}},
+		NPredict: 10,
+	})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	var content strings.Builder
+	var stopped bool
+	for resp := range respChan {
+		content.WriteString(resp.Content)
+		stopped = resp.Stop
+	}
+	if got := content.String(); got != "Paris is the capital." {
+		t.Errorf("content = %q, want %q", got, "Paris is the capital.")
+	}
+	if !stopped {
+		t.Error("last response was not a stop event")
+	}
+
+	reqs := fake.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("fake got %d requests, want 1", len(reqs))
+	}
+	if reqs[0]["stream"] != true || reqs[0]["prompt"] != "what is the capital of france?" {
+		t.Errorf("unexpected request body: %v", reqs[0])
+	}
+	if reqs[0]["temperature"] != 0.5 || reqs[0]["top_k"] != 20.0 || fmt.Sprint(reqs[0]["stop"]) != "[\n\n]" {
+		t.Errorf("sampling parameters not sent as llama-server expects them: %v", reqs[0])
+	}
+	if _, ok := reqs[0]["top_p"]; ok {
+		t.Errorf("unset sampling parameters should be omitted: %v", reqs[0])
+	}
+}
+
+func TestInferenceGreedy(t *testing.T) {
+	s, fake := newFakeBackedServer(t, llamatest.Config{Tokens: []string{"I went."}}, true)
+
+	respChan, err := s.Inference(context.Background(), InferenceReq{
+		Prompt:   "I has went.",
+		Sampling: Sampling{Temp: Float(0), TopK: Int(0), Seed: Int(0)},
+	})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	for range respChan {
+	}
+	req := fake.Requests()[0]
+	for _, key := range []string{"temperature", "top_k", "seed"} {
+		if v, ok := req[key]; !ok || v != 0.0 {
+			t.Errorf("%s = %v, want an explicit 0", key, v)
+		}
+	}
+}
+
+func TestInferenceServerError(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{CompletionStatus: 500}, true)
+
+	_, err := s.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != 500 {
+		t.Fatalf("err = %v, want a *ServerError with code 500", err)
+	}
+}
+
+// drain collects a response stream, returning the concatenated content and
+// the error carried by the final event, if any.
+func drain(t *testing.T, respChan <-chan InferenceResp) (string, error) {
+	t.Helper()
+	var content strings.Builder
+	var err error
+	for resp := range respChan {
+		if err != nil {
+			t.Fatalf("got an event after the error event: %+v", resp)
+		}
+		content.WriteString(resp.Content)
+		err = resp.Err
+	}
+	return content.String(), err
+}
+
+func TestInferenceStreamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     llamatest.Config
+		content string
+		check   func(error) bool
+	}{
+		{
+			name:    "dropped connection",
+			cfg:     llamatest.Config{Tokens: []string{"a", "b", "c"}, DropAfter: 2},
+			content: "ab",
+			check:   func(err error) bool { return errors.Is(err, ErrIncompleteStream) },
+		},
+		{
+			name:    "no stop event",
+			cfg:     llamatest.Config{Events: []string{`{"content":"a","stop":false}`}},
+			content: "a",
+			check:   func(err error) bool { return errors.Is(err, ErrIncompleteStream) },
+		},
+		{
+			name:    "malformed event",
+			cfg:     llamatest.Config{Events: []string{`{"content":"a","stop":false}`, `{"content":`}},
+			content: "a",
+			check: func(err error) bool {
+				var malformed *MalformedEventError
+				return errors.As(err, &malformed) && malformed.Data == `{"content":`
+			},
+		},
+		{
+			name: "error payload",
+			cfg: llamatest.Config{Events: []string{
+				`{"content":"a","stop":false}`,
+				`{"error":{"code":500,"message":"out of memory","type":"server_error"}}`,
+			}},
+			content: "a",
+			check: func(err error) bool {
+				var serverErr *ServerError
+				return errors.As(err, &serverErr) && serverErr.Message == "out of memory"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newFakeBackedServer(t, tt.cfg, true)
+
+			respChan, err := s.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+			if err != nil {
+				t.Fatalf("Inference: %v", err)
+			}
+			content, err := drain(t, respChan)
+			if content != tt.content {
+				t.Errorf("content = %q, want %q", content, tt.content)
+			}
+			if !tt.check(err) {
+				t.Errorf("unexpected stream error: %v", err)
+			}
+		})
+	}
+}
+
+func TestInferenceCancel(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{
+		Tokens:     []string{"a", "b", "c", "d", "e"},
+		TokenDelay: time.Second,
+	}, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	respChan, err := s.Inference(ctx, InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	if resp := <-respChan; resp.Content != "a" {
+		t.Fatalf("first event = %+v", resp)
+	}
+	cancel()
+
+	start := time.Now()
+	_, err = drain(t, respChan)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("stream error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancellation took %v", elapsed)
+	}
+}
+
+func TestIsModelCached(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K_M.gguf",
+		"unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q8_0.gguf.downloadInProgress",
+		"unsloth_Qwen3-1.7B-GGUF_Qwen3-1.7B-Q4_K_M.gguf.downloadInProgress",
+		"unsloth_Qwen3-0.6B-GGUF_Qwen3-0.6B-Q8_0.gguf",
+		"unsloth_SmolLM2-GGUF_SmolLM2-Q4_K_M.gguf",
+		"unsloth_SmolLM2-GGUF_SmolLM2-Q8_0.gguf",
+		"unsloth_Phi-4-GGUF_Phi-4-Q4_K_M.gguf",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the latest tags of SmolLM2 and Phi-4 point at their Q8_0 files
+	for _, repo := range []string{"SmolLM2", "Phi-4"} {
+		manifest := `{"ggufFile":{"rfilename":"` + repo + `-Q8_0.gguf"}}`
+		if err := os.WriteFile(filepath.Join(dir, "manifest=unsloth="+repo+"-GGUF=latest.json"), []byte(manifest), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		llm  string
+		want bool
+	}{
+		{"unsloth/gemma-3-1b-it-GGUF", true},
+		{"unsloth/gemma-3-1b-it-GGUF:q4_k_m", true},
+		{"unsloth/gemma-3-1b-it-GGUF:Q8_0", false},
+		{"unsloth/gemma-3-1b-it-GGUF:F16", false},
+		{"/models/nomodit-Q4_K_M.gguf", true},
+		{"unsloth/Qwen3-1.7B-GGUF", false},
+		{"unsloth/Qwen3-0.6B-GGUF", false}, // only a non-default quantization
+		{"unsloth/Qwen3-0.6B-GGUF:Q8_0", true},
+		{"unsloth/SmolLM2-GGUF", true},
+		{"unsloth/Phi-4-GGUF", false},
+		{"unsloth/Phi-4-GGUF:Q4_K_M", true},
+		{"unsloth/missing-GGUF", false},
+	}
+	for _, tt := range tests {
+		got, err := isModelCached(tt.llm, dir)
+		if err != nil {
+			t.Fatalf("isModelCached(%q): %v", tt.llm, err)
+		}
+		if got != tt.want {
+			t.Errorf("isModelCached(%q) = %v, want %v", tt.llm, got, tt.want)
+		}
+	}
+
+	if got, err := isModelCached("unsloth/gemma-3-1b-it-GGUF", filepath.Join(dir, "missing")); err != nil || got {
+		t.Errorf("missing cache dir: got %v, %v; want false, nil", got, err)
+	}
+}
+
+func TestCheckPortFree(t *testing.T) {
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("freePort: %v", err)
+	}
+	if err := checkPortFree(port); err != nil {
+		t.Fatalf("checkPortFree(%s) on a free port: %v", port, err)
+	}
+
+	fake := llamatest.NewServer(llamatest.Config{})
+	defer fake.Close()
+	_, busy, _ := strings.Cut(fake.Listener.Addr().String(), ":")
+	if err := checkPortFree(busy); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("checkPortFree(%s) on a bound port = %v, want ErrPortInUse", busy, err)
+	}
+	if _, err := StartServer("test/model-GGUF", busy); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("StartServer on a bound port = %v, want ErrPortInUse", err)
+	}
+}
+
+func TestRemoteServer(t *testing.T) {
+	fake := llamatest.NewServer(llamatest.Config{LoadingPolls: 1, Tokens: []string{"ok"}})
+	defer fake.Close()
+
+	s := NewRemoteServer(fake.URL + "/")
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer s.Stop()
+	if s.llamaCmd != nil {
+		t.Fatal("remote server must not spawn a process")
+	}
+
+	statuses := collectStatuses(t, s)
+	if first := statuses[0].Message; first != "Connecting to llama-server at "+fake.URL {
+		t.Errorf("first status = %q", first)
+	}
+	if last := statuses[len(statuses)-1]; last.IsError || last.Message != "Server is ready" {
+		t.Errorf("last status = %+v, want ready", last)
+	}
+
+	respChan, err := s.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	var content string
+	for resp := range respChan {
+		content += resp.Content
+	}
+	if content != "ok" {
+		t.Errorf("content = %q, want %q", content, "ok")
+	}
+}
+
+func TestRemoteServerInvalidURL(t *testing.T) {
+	for _, u := range []string{"", "localhost:8080", "ftp://localhost:8080"} {
+		if err := NewRemoteServer(u).Start(); err == nil {
+			t.Errorf("Start with URL %q succeeded, want error", u)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+// Package llamatest provides an in-process stand-in for llama-server so the
+// llama package (and anything built on it) can be tested without the real
+// binary or a model download.
+package llamatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes how a fake llama-server behaves. The zero value is a server
+// that is immediately healthy and streams an empty completion.
+type Config struct {
+	// LoadingPolls is the number of /health requests answered with 503 before
+	// the server reports ready.
+	LoadingPolls int
+	// HealthStatus, if set, is returned by /health instead of 200 once loading is done.
+	HealthStatus int
+
+	// Stderr lines are written, in order, to the reader returned by Stderr.
+	// /health keeps answering 503 until all of them have been read.
+	Stderr []string
+
+	// Tokens are streamed by /completion and /v1/chat/completions as one SSE
+	// event each, followed by a final stop event.
+	Tokens []string
+	// Events, if set, replace Tokens: each one is sent verbatim as an SSE data
+	// payload, which allows malformed or error events to be injected.
+	Events []string
+	// TokenDelay is slept between streamed events.
+	TokenDelay time.Duration
+	// CompletionStatus, if set, is returned by /completion instead of streaming.
+	CompletionStatus int
+	// DropAfter, if positive, ends the stream abruptly after that many events,
+	// without a stop event.
+	DropAfter int
+
+	// ContextSize is the n_ctx reported by /props, 4096 if zero.
+	ContextSize int
+	// Slots is the total_slots reported by /props, 1 if zero.
+	Slots int
+	// ChatTemplate is the chat_template reported by /props.
+	ChatTemplate string
+	// Probs are the probabilities of Tokens, reported when a request sets
+	// n_probs or logprobs. Tokens without one have a probability of 1.
+	Probs []float64
+}
+
+// Server is a fake llama-server listening on a loopback address.
+type Server struct {
+	*httptest.Server
+
+	cfg Config
+
+	mu           sync.Mutex
+	healthPolls  int
+	stderrDone   bool
+	requests     []map[string]any
+	vocab        []string         // words seen by /tokenize, indexed by token id
+	ids          map[string]int   // inverse of vocab
+	slotPrompts  map[int][]string // words of the last prompt of each slot
+	loraScales   [][]float64      // every POST to /lora-adapters
+	stderrReader *io.PipeReader
+	stderrWriter *io.PipeWriter
+}
+
+// NewServer starts a fake llama-server configured by cfg. Callers should call
+// Close when finished.
+func NewServer(cfg Config) *Server {
+	s := &Server{cfg: cfg}
+	s.stderrReader, s.stderrWriter = io.Pipe()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/completion", s.handleCompletion)
+	mux.HandleFunc("/v1/chat/completions", s.handleChat)
+	mux.HandleFunc("/tokenize", s.handleTokenize)
+	mux.HandleFunc("/detokenize", s.handleDetokenize)
+	mux.HandleFunc("/props", s.handleProps)
+	mux.HandleFunc("/embedding", s.handleEmbedding)
+	mux.HandleFunc("/lora-adapters", s.handleLoRA)
+	s.Server = httptest.NewServer(mux)
+
+	go s.writeStderr()
+	return s
+}
+
+// Stderr returns the fake process' stderr stream.
+func (s *Server) Stderr() io.ReadCloser {
+	return s.stderrReader
+}
+
+// Requests returns the decoded bodies of every /completion and
+// /v1/chat/completions request received so far.
+func (s *Server) Requests() []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]any(nil), s.requests...)
+}
+
+// LoRAScales returns the adapter scales posted to /lora-adapters so far,
+// each indexed by adapter id.
+func (s *Server) LoRAScales() [][]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]float64(nil), s.loraScales...)
+}
+
+// Close shuts down the HTTP server and ends the stderr stream.
+func (s *Server) Close() {
+	s.Server.Close()
+	s.stderrWriter.Close()
+}
+
+func (s *Server) writeStderr() {
+	for _, line := range s.cfg.Stderr {
+		if _, err := fmt.Fprintln(s.stderrWriter, line); err != nil {
+			break // reader went away
+		}
+	}
+	s.mu.Lock()
+	s.stderrDone = true
+	s.mu.Unlock()
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	loading := !s.stderrDone || s.healthPolls < s.cfg.LoadingPolls
+	s.healthPolls++
+	s.mu.Unlock()
+
+	switch {
+	case loading:
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"error": map[string]any{"code": 503, "message": "Loading model", "type": "unavailable_error"},
+		})
+	case s.cfg.HealthStatus != 0:
+		writeJSON(w, s.cfg.HealthStatus, map[string]any{"status": "error"})
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+	}
+}
+
+// handleTokenize makes every whitespace-separated word one token, so tests
+// can predict token counts.
+func (s *Server) handleTokenize(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	if s.ids == nil {
+		s.ids = map[string]int{}
+	}
+	tokens := []int{}
+	for _, word := range strings.Fields(req.Content) {
+		id, ok := s.ids[word]
+		if !ok {
+			id = len(s.vocab)
+			s.ids[word] = id
+			s.vocab = append(s.vocab, word)
+		}
+		tokens = append(tokens, id)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"tokens": tokens})
+}
+
+func (s *Server) handleDetokenize(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tokens []int `json:"tokens"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	words := make([]string, 0, len(req.Tokens))
+	for _, id := range req.Tokens {
+		if id >= 0 && id < len(s.vocab) {
+			words = append(words, s.vocab[id])
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"content": strings.Join(words, " ")})
+}
+
+// handleLoRA records the scales set through POST /lora-adapters.
+func (s *Server) handleLoRA(w http.ResponseWriter, r *http.Request) {
+	var req []struct {
+		ID    int     `json:"id"`
+		Scale float64 `json:"scale"`
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is faked", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scales := make([]float64, len(req))
+	for _, a := range req {
+		if a.ID < 0 || a.ID >= len(req) {
+			http.Error(w, "invalid adapter id", http.StatusBadRequest)
+			return
+		}
+		scales[a.ID] = a.Scale
+	}
+	s.mu.Lock()
+	s.loraScales = append(s.loraScales, scales)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"success": true})
+}
+
+// embeddingDims is the size of the fake embeddings.
+const embeddingDims = 64
+
+// handleEmbedding embeds each content as a bag of its lower-cased words
+// hashed into embeddingDims buckets, so texts sharing more words are more
+// similar.
+func (s *Server) handleEmbedding(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content []string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items := []map[string]any{}
+	for i, content := range req.Content {
+		vec := make([]float64, embeddingDims)
+		for _, word := range strings.Fields(strings.ToLower(content)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(word, ".,;:!?\"'")))
+			vec[h.Sum32()%embeddingDims]++
+		}
+		items = append(items, map[string]any{"index": i, "embedding": [][]float64{vec}})
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *Server) handleProps(w http.ResponseWriter, r *http.Request) {
+	nCtx := s.cfg.ContextSize
+	if nCtx == 0 {
+		nCtx = 4096
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"default_generation_settings": map[string]any{"n_ctx": nCtx},
+		"total_slots":                 max(s.cfg.Slots, 1),
+		"chat_template":               s.cfg.ChatTemplate,
+	})
+}
+
+func (s *Server) handleCompletion(w http.ResponseWriter, r *http.Request) {
+	s.stream(w, r, func(i int, gen generation) any {
+		stop := i == len(s.cfg.Tokens)
+		event := map[string]any{"content": s.token(i), "stop": stop}
+		if gen.probs && !stop {
+			event["completion_probabilities"] = []any{s.tokenProb(i)}
+		}
+		if stop {
+			event["stop_type"] = "eos"
+			event["truncated"] = false
+			event["tokens_predicted"] = len(s.cfg.Tokens)
+			event["tokens_evaluated"] = PromptTokens
+			event["timings"] = s.timings(gen.cached)
+		}
+		return event
+	}, false)
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	s.stream(w, r, func(i int, gen generation) any {
+		stop := i == len(s.cfg.Tokens)
+		choice := map[string]any{"index": 0, "delta": map[string]any{"content": s.token(i)}, "finish_reason": nil}
+		chunk := map[string]any{"object": "chat.completion.chunk", "choices": []any{choice}}
+		if gen.probs && !stop {
+			choice["logprobs"] = map[string]any{"content": []any{s.tokenProb(i)}}
+		}
+		if stop {
+			choice["delta"] = map[string]any{}
+			choice["finish_reason"] = "stop"
+			chunk["usage"] = map[string]any{
+				"completion_tokens": len(s.cfg.Tokens),
+				"prompt_tokens":     PromptTokens,
+				"total_tokens":      len(s.cfg.Tokens) + PromptTokens,
+			}
+			chunk["timings"] = s.timings(gen.cached)
+		}
+		return chunk
+	}, true)
+}
+
+// generation is what the fake makes of a generation request.
+type generation struct {
+	cached int  // prompt tokens found in the slot's cache
+	probs  bool // the request asked for token probabilities
+}
+
+// token returns Tokens[i], or "" for the stop event that follows them.
+func (s *Server) token(i int) string {
+	if i < len(s.cfg.Tokens) {
+		return s.cfg.Tokens[i]
+	}
+	return ""
+}
+
+// tokenProb describes Tokens[i] the way llama-server's probabilities do.
+func (s *Server) tokenProb(i int) map[string]any {
+	p := 1.0
+	if i < len(s.cfg.Probs) {
+		p = s.cfg.Probs[i]
+	}
+	return map[string]any{"id": i, "token": s.cfg.Tokens[i], "logprob": math.Log(p), "top_logprobs": []any{}}
+}
+
+// stream answers a generation request, encoding each of Tokens, then the stop
+// event (i == len(Tokens)), with event. Chat streams are terminated with
+// OpenAI's [DONE] marker.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, event func(i int, gen generation) any, done bool) {
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	gen := generation{cached: s.cachePrompt(req)}
+	s.mu.Unlock()
+	nProbs, _ := req["n_probs"].(float64)
+	logprobs, _ := req["logprobs"].(bool)
+	gen.probs = nProbs > 0 || logprobs
+
+	if s.cfg.CompletionStatus != 0 {
+		writeJSON(w, s.cfg.CompletionStatus, map[string]any{
+			"error": map[string]any{"code": s.cfg.CompletionStatus, "message": "fake failure", "type": "server_error"},
+		})
+		return
+	}
+
+	events := s.cfg.Events
+	if events == nil {
+		for i := range len(s.cfg.Tokens) + 1 {
+			events = append(events, mustJSON(event(i, gen)))
+		}
+		if done {
+			events = append(events, "[DONE]")
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for i, event := range events {
+		if s.cfg.DropAfter > 0 && i >= s.cfg.DropAfter {
+			panic(http.ErrAbortHandler) // cut the connection mid-stream
+		}
+		if i > 0 && s.cfg.TokenDelay > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(s.cfg.TokenDelay):
+			}
+		}
+		fmt.Fprintf(w, "data: %s\n\n", event)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// cachePrompt stores the words of the prompt of req in its slot (id_slot, or
+// slot 0) and returns how many of its tokens the slot had cached: with
+// cache_prompt, the words it shares with the slot's last prompt, up to all but
+// one of PromptTokens. s.mu must be held.
+func (s *Server) cachePrompt(req map[string]any) int {
+	var words []string
+	if prompt, ok := req["prompt"].(string); ok {
+		words = strings.Fields(prompt)
+	}
+	messages, _ := req["messages"].([]any)
+	for _, m := range messages {
+		if m, ok := m.(map[string]any); ok {
+			content, _ := m["content"].(string)
+			words = append(words, strings.Fields(content)...)
+		}
+	}
+	slot := 0
+	if id, ok := req["id_slot"].(float64); ok {
+		slot = int(id)
+	}
+	if s.slotPrompts == nil {
+		s.slotPrompts = make(map[int][]string)
+	}
+	previous := s.slotPrompts[slot]
+	s.slotPrompts[slot] = words
+	if use, _ := req["cache_prompt"].(bool); !use {
+		return 0
+	}
+	cached := 0
+	for cached < len(words) && cached < len(previous) && words[cached] == previous[cached] {
+		cached++
+	}
+	// the last prompt token is always evaluated, to get the first logits
+	return min(cached, PromptTokens-1)
+}
+
+// PromptTokens is the prompt size the fake reports for every request.
+const PromptTokens = 12
+
+// timings reports a generation speed of one token per TokenDelay (or 1ms),
+// and a prompt evaluation of 1ms per token that wasn't cached.
+func (s *Server) timings(cached int) map[string]any {
+	perToken := max(s.cfg.TokenDelay, time.Millisecond)
+	predictedMS := float64(len(s.cfg.Tokens)) * float64(perToken) / float64(time.Millisecond)
+	promptN := PromptTokens - cached
+	return map[string]any{
+		"cache_n":              cached,
+		"prompt_n":             promptN,
+		"prompt_ms":            float64(promptN),
+		"prompt_per_second":    1000.0,
+		"predicted_n":          len(s.cfg.Tokens),
+		"predicted_ms":         predictedMS,
+		"predicted_per_second": float64(time.Second) / float64(perToken),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func mustJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,130 @@
+package llama
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// LoRA is an adapter llama-server loads alongside the model, see
+// Options.LoRA. Requests pick one by Name.
+type LoRA struct {
+	Name string
+	Path string // local GGUF
+}
+
+// ParseLoRA parses a name=path adapter spec.
+func ParseLoRA(spec string) (LoRA, error) {
+	name, path, ok := strings.Cut(spec, "=")
+	name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+	if !ok || name == "" || path == "" {
+		return LoRA{}, fmt.Errorf("invalid LoRA adapter %q: want name=path", spec)
+	}
+	return LoRA{Name: name, Path: path}, nil
+}
+
+// loraGate tracks which adapter llama-server applies. Its scales are global,
+// so requests for different adapters can't overlap: a switch waits until the
+// requests using the current adapter are done, and holds back new ones for
+// that adapter meanwhile.
+type loraGate struct {
+	mu       sync.Mutex
+	applied  bool   // active is what the server applies
+	active   string // adapter name, "" for the bare model
+	inFlight int
+	idle     chan struct{} // closed when inFlight drops to 0
+	switches int           // requests waiting to switch adapter
+}
+
+// useLoRA waits until the adapter called name (or none, for "") can be
+// applied, switching to it through /lora-adapters if needed. The request
+// must call release once its stream is over.
+func (s *Server) useLoRA(ctx context.Context, name string) (release func(), err error) {
+	g := &s.lora
+	if name == "" && len(s.opts.LoRA) == 0 {
+		return func() {}, nil
+	}
+	if name != "" && !s.hasLoRA(name) {
+		return nil, fmt.Errorf("unknown LoRA adapter %q", name)
+	}
+
+	g.mu.Lock()
+	waiting := false
+	for g.inFlight > 0 && (g.active != name || (g.switches > 0 && !waiting)) {
+		if g.active != name && !waiting {
+			waiting = true
+			g.switches++
+		}
+		idle := g.idle
+		g.mu.Unlock()
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			g.mu.Lock()
+			if waiting {
+				g.switches--
+			}
+			g.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		g.mu.Lock()
+	}
+	if waiting {
+		g.switches--
+	}
+	defer g.mu.Unlock()
+
+	if !g.applied || g.active != name {
+		if err := s.setLoRA(ctx, name); err != nil {
+			g.applied = false
+			return nil, err
+		}
+		g.applied, g.active = true, name
+	}
+	if g.inFlight == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.inFlight++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.inFlight--; g.inFlight == 0 {
+				close(g.idle)
+			}
+		})
+	}, nil
+}
+
+func (s *Server) hasLoRA(name string) bool {
+	for _, a := range s.opts.LoRA {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setLoRA scales the adapter called name to 1 and every other to 0. The ids
+// llama-server gives the adapters follow the order of --lora.
+func (s *Server) setLoRA(ctx context.Context, name string) error {
+	type scale struct {
+		ID    int     `json:"id"`
+		Scale float64 `json:"scale"`
+	}
+	scales := make([]scale, len(s.opts.LoRA))
+	for i, a := range s.opts.LoRA {
+		scales[i] = scale{ID: i}
+		if a.Name == name {
+			scales[i].Scale = 1
+		}
+	}
+	var resp any
+	if err := s.call(ctx, http.MethodPost, "/lora-adapters", scales, &resp); err != nil {
+		return fmt.Errorf("switching LoRA adapter: %w", err)
+	}
+	return nil
+}
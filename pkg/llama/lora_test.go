@@ -0,0 +1,104 @@
+package llama
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestParseLoRA(t *testing.T) {
+	got, err := ParseLoRA("simplify = ./adapters/simplify.gguf")
+	if want := (LoRA{"simplify", "./adapters/simplify.gguf"}); err != nil || got != want {
+		t.Errorf("ParseLoRA = %+v, %v; want %+v", got, err, want)
+	}
+	for _, spec := range []string{"simplify.gguf", "=x.gguf", "simplify="} {
+		if _, err := ParseLoRA(spec); err == nil {
+			t.Errorf("ParseLoRA(%q) succeeded", spec)
+		}
+	}
+}
+
+func newLoRAServer(t *testing.T, cfg llamatest.Config) (*Server, *llamatest.Server) {
+	t.Helper()
+	s, fake := newFakeBackedServer(t, cfg, true)
+	s.opts.LoRA = []LoRA{{"grammar", "gec.gguf"}, {"simplify", "simple.gguf"}}
+	return s, fake
+}
+
+func TestLoRASwitching(t *testing.T) {
+	s, fake := newLoRAServer(t, llamatest.Config{Tokens: []string{"ok"}})
+	for _, name := range []string{"grammar", "grammar", "simplify", ""} {
+		events, err := s.Inference(context.Background(), InferenceReq{Prompt: "hi", LoRA: name})
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		for range events {
+		}
+	}
+	// the second grammar request finds its adapter applied
+	want := [][]float64{{1, 0}, {0, 1}, {0, 0}}
+	if got := fake.LoRAScales(); !reflect.DeepEqual(got, want) {
+		t.Errorf("scales = %v, want %v", got, want)
+	}
+
+	if _, err := s.Chat(context.Background(), ChatReq{LoRA: "neutralize"}); err == nil {
+		t.Error("expected an error for an adapter that wasn't loaded")
+	}
+}
+
+func TestLoRAWaitsForOtherAdapter(t *testing.T) {
+	s, fake := newLoRAServer(t, llamatest.Config{Tokens: []string{"a", "b", "c"}, TokenDelay: 20 * time.Millisecond})
+	ctx := context.Background()
+
+	grammar, err := s.Inference(ctx, InferenceReq{Prompt: "hi", LoRA: "grammar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	switched := make(chan struct{})
+	go func() {
+		events, err := s.Inference(ctx, InferenceReq{Prompt: "hi", LoRA: "simplify"})
+		if err != nil {
+			t.Error(err)
+		}
+		close(switched)
+		for range events {
+		}
+	}()
+	time.Sleep(10 * time.Millisecond) // the grammar stream takes 40ms
+	select {
+	case <-switched:
+		t.Fatal("switched adapters while the grammar stream was running")
+	default:
+	}
+	if n := len(fake.LoRAScales()); n != 1 {
+		t.Errorf("%d switches while the grammar stream was running, want 1", n)
+	}
+	for range grammar {
+	}
+	<-switched
+	if n := len(fake.LoRAScales()); n != 2 {
+		t.Errorf("%d switches, want 2", n)
+	}
+
+	// a request that gives up waiting leaves the gate usable
+	held, err := s.Inference(ctx, InferenceReq{Prompt: "hi", LoRA: "grammar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Inference(short, InferenceReq{Prompt: "hi", LoRA: "simplify"}); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+	for range held {
+	}
+	events, err := s.Inference(ctx, InferenceReq{Prompt: "hi", LoRA: "simplify"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+}
@@ -0,0 +1,64 @@
+package llama
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timings are llama-server's measurements for one generation.
+type Timings struct {
+	CacheN             int     `json:"cache_n"` // prompt tokens reused from the KV cache
+	PromptN            int     `json:"prompt_n"`
+	PromptMS           float64 `json:"prompt_ms"`
+	PromptPerSecond    float64 `json:"prompt_per_second"`
+	PredictedN         int     `json:"predicted_n"`
+	PredictedMS        float64 `json:"predicted_ms"`
+	PredictedPerSecond float64 `json:"predicted_per_second"`
+}
+
+// Result describes a finished generation. It is attached to the final event
+// of a stream.
+type Result struct {
+	TokensPredicted int     `json:"tokens_predicted"`
+	TokensEvaluated int     `json:"tokens_evaluated"`
+	Truncated       bool    `json:"truncated"`
+	StopType        string  `json:"stop_type"` // "eos", "word" or "limit"
+	Timings         Timings `json:"timings"`
+	// TimeToFirstToken is measured by nomodit, from sending the request to
+	// receiving the first generated text.
+	TimeToFirstToken time.Duration `json:"-"`
+}
+
+// Summary formats the headline numbers for a status line.
+func (r *Result) Summary() string {
+	parts := []string{fmt.Sprintf("%.1f tok/s", r.Timings.PredictedPerSecond)}
+	if r.TimeToFirstToken > 0 {
+		parts = append(parts, "TTFT "+r.TimeToFirstToken.Round(time.Millisecond).String())
+	}
+	promptPart := fmt.Sprintf("prompt %d tok", r.TokensEvaluated)
+	if r.Timings.PromptMS > 0 {
+		// only the tokens that weren't cached are evaluated
+		promptPart += fmt.Sprintf(" in %s", time.Duration(r.Timings.PromptMS*float64(time.Millisecond)).Round(time.Millisecond))
+	}
+	parts = append(parts, promptPart, fmt.Sprintf("generated %d tok", r.TokensPredicted))
+	if r.Timings.CacheN > 0 {
+		parts = append(parts, fmt.Sprintf("cached %d tok", r.Timings.CacheN))
+	}
+	if r.Truncated || r.StopType == "limit" {
+		parts = append(parts, "truncated")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// firstToken records when a stream produced its first text.
+type firstToken struct {
+	start time.Time
+	ttft  time.Duration
+}
+
+func (f *firstToken) observe(resp InferenceResp) {
+	if f.ttft == 0 && (resp.Content != "" || resp.Reasoning != "") {
+		f.ttft = time.Since(f.start)
+	}
+}
@@ -0,0 +1,100 @@
+package llama
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func finalResult(t *testing.T, respChan <-chan InferenceResp) *Result {
+	t.Helper()
+	var last InferenceResp
+	for resp := range respChan {
+		if resp.Err != nil {
+			t.Fatalf("stream error: %v", resp.Err)
+		}
+		if resp.Result != nil && !resp.Stop {
+			t.Errorf("Result set on a non-final event: %+v", resp)
+		}
+		last = resp
+	}
+	if !last.Stop || last.Result == nil {
+		t.Fatalf("final event %+v has no Result", last)
+	}
+	return last.Result
+}
+
+func TestInferenceResult(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{Tokens: []string{"a", "b", "c", "d"}, TokenDelay: 10 * time.Millisecond}, true)
+
+	respChan, err := s.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	r := finalResult(t, respChan)
+	if r.TokensPredicted != 4 || r.TokensEvaluated != llamatest.PromptTokens || r.StopType != "eos" || r.Truncated {
+		t.Errorf("unexpected result: %+v", r)
+	}
+	if r.Timings.PredictedPerSecond != 100 || r.Timings.PromptN != llamatest.PromptTokens {
+		t.Errorf("unexpected timings: %+v", r.Timings)
+	}
+	if r.TimeToFirstToken <= 0 {
+		t.Errorf("TimeToFirstToken = %v, want it measured", r.TimeToFirstToken)
+	}
+}
+
+func TestChatResult(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{Tokens: []string{"a", "b"}}, true)
+
+	respChan, err := s.Chat(context.Background(), ChatReq{Messages: []ChatMessage{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	r := finalResult(t, respChan)
+	if r.TokensPredicted != 2 || r.TokensEvaluated != llamatest.PromptTokens || r.StopType != "eos" || r.Timings.PredictedN != 2 {
+		t.Errorf("unexpected result: %+v", r)
+	}
+}
+
+func TestChatResultAfterFinish(t *testing.T) {
+	// usage and timings may arrive in a chunk of their own after the finish reason
+	s, _ := newFakeBackedServer(t, llamatest.Config{Events: []string{
+		`{"choices":[{"index":0,"delta":{"content":"a"},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`,
+		`{"choices":[],"usage":{"completion_tokens":1,"prompt_tokens":3},"timings":{"predicted_per_second":42}}`,
+		`[DONE]`,
+	}}, true)
+
+	respChan, err := s.Chat(context.Background(), ChatReq{Messages: []ChatMessage{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	r := finalResult(t, respChan)
+	if r.TokensPredicted != 1 || r.TokensEvaluated != 3 || r.Timings.PredictedPerSecond != 42 || !r.Truncated {
+		t.Errorf("unexpected result: %+v", r)
+	}
+}
+
+func TestResultSummary(t *testing.T) {
+	r := &Result{
+		TokensPredicted:  20,
+		TokensEvaluated:  35,
+		StopType:         "limit",
+		Timings:          Timings{PredictedPerSecond: 42.13, CacheN: 30},
+		TimeToFirstToken: 180 * time.Millisecond,
+	}
+	want := "42.1 tok/s · TTFT 180ms · prompt 35 tok · generated 20 tok · cached 30 tok · truncated"
+	if got := r.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+	r.Timings.PromptMS = 4.4
+	if got := r.Summary(); !strings.Contains(got, "prompt 35 tok in 4ms") {
+		t.Errorf("Summary() = %q, want the prompt time", got)
+	}
+	if got := (&Result{}).Summary(); strings.Contains(got, "TTFT") {
+		t.Errorf("Summary() without TTFT = %q", got)
+	}
+}
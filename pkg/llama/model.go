@@ -0,0 +1,146 @@
+package llama
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ModelRef is a parsed model argument: a Hugging Face repo, optionally pinned
+// to one quantization ("unsloth/gemma-3-1b-it-GGUF:Q8_0"), or a GGUF file on disk.
+type ModelRef struct {
+	Repo  string // e.g. "unsloth/gemma-3-1b-it-GGUF"
+	Quant string // e.g. "Q8_0", empty for llama.cpp's default pick
+	Path  string // absolute path of a local GGUF, set instead of Repo
+}
+
+// ParseModelRef parses repo, repo:quant or a path to a local GGUF file.
+// Anything ending in .gguf or starting like a path (/, ./, ../, ~/) is a path.
+func ParseModelRef(s string) (ModelRef, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ModelRef{}, errors.New("no model given")
+	}
+	if isLocalModel(s) {
+		path, err := expandPath(s)
+		if err != nil {
+			return ModelRef{}, err
+		}
+		return ModelRef{Path: path}, nil
+	}
+
+	repo, quant, hasQuant := strings.Cut(s, ":")
+	user, name, ok := strings.Cut(repo, "/")
+	if !ok || user == "" || name == "" || strings.Contains(name, "/") {
+		return ModelRef{}, fmt.Errorf("invalid model %q: want <user>/<repo>[:quant] or a path to a .gguf file", s)
+	}
+	if hasQuant && quant == "" {
+		return ModelRef{}, fmt.Errorf("invalid model %q: empty quantization after ':'", s)
+	}
+	return ModelRef{Repo: repo, Quant: quant}, nil
+}
+
+// Local reports whether the model is a file on disk rather than a repo.
+func (r ModelRef) Local() bool {
+	return r.Path != ""
+}
+
+// String returns the form llama-server's -hf flag takes, or the file path.
+func (r ModelRef) String() string {
+	switch {
+	case r.Local():
+		return r.Path
+	case r.Quant != "":
+		return r.Repo + ":" + r.Quant
+	default:
+		return r.Repo
+	}
+}
+
+// args returns the llama-server flags loading r, using local for files and
+// hf for repos, e.g. -m/-hf or -md/-hfd for a draft model.
+func (r ModelRef) args(local, hf string) []string {
+	if r.Local() {
+		return []string{local, r.Path}
+	}
+	return []string{hf, r.String()}
+}
+
+// check fails if a local model file is missing, so we don't find out from
+// llama-server's stderr after it has already started.
+func (r ModelRef) check() error {
+	if !r.Local() {
+		return nil
+	}
+	return checkModelFile(r.Path)
+}
+
+func checkModelFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("model file: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("model file %s is a directory", path)
+	}
+	return nil
+}
+
+func isLocalModel(s string) bool {
+	if strings.HasSuffix(strings.ToLower(s), ".gguf") || filepath.IsAbs(s) {
+		return true
+	}
+	for _, prefix := range []string{"./", "../", "~/", "." + string(filepath.Separator), ".." + string(filepath.Separator)} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func expandPath(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, rest)
+	}
+	return filepath.Abs(path)
+}
+
+// modelArgs returns the llama-server flags that load llm and the extras in opts.
+func modelArgs(llm string, opts Options) ([]string, error) {
+	ref, err := ParseModelRef(llm)
+	if err != nil {
+		return nil, err
+	}
+	if err := ref.check(); err != nil {
+		return nil, err
+	}
+	args := ref.args("-m", "-hf")
+
+	if opts.MMProj != "" {
+		path, err := expandPath(opts.MMProj)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkModelFile(path); err != nil {
+			return nil, fmt.Errorf("mmproj: %w", err)
+		}
+		args = append(args, "--mmproj", path)
+	}
+	if opts.Draft != "" {
+		draft, err := ParseModelRef(opts.Draft)
+		if err != nil {
+			return nil, fmt.Errorf("draft: %w", err)
+		}
+		if err := draft.check(); err != nil {
+			return nil, fmt.Errorf("draft: %w", err)
+		}
+		args = append(args, draft.args("-md", "-hfd")...)
+	}
+	return args, nil
+}
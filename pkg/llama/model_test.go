@@ -0,0 +1,83 @@
+package llama
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseModelRef(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in      string
+		want    ModelRef
+		wantErr bool
+	}{
+		{in: "unsloth/gemma-3-1b-it-GGUF", want: ModelRef{Repo: "unsloth/gemma-3-1b-it-GGUF"}},
+		{in: "unsloth/gemma-3-1b-it-GGUF:Q8_0", want: ModelRef{Repo: "unsloth/gemma-3-1b-it-GGUF", Quant: "Q8_0"}},
+		{in: "./out/nomodit-Q4_K_M.gguf", want: ModelRef{Path: filepath.Join(wd, "out", "nomodit-Q4_K_M.gguf")}},
+		{in: "model.GGUF", want: ModelRef{Path: filepath.Join(wd, "model.GGUF")}},
+		{in: "/models/nomodit", want: ModelRef{Path: "/models/nomodit"}},
+		{in: "", wantErr: true},
+		{in: "gemma", wantErr: true},
+		{in: "a/b/c", wantErr: true},
+		{in: "unsloth/gemma:", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseModelRef(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseModelRef(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseModelRef(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestModelArgs(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "nomodit-Q4_K_M.gguf")
+	mmproj := filepath.Join(dir, "mmproj-F16.gguf")
+	for _, path := range []string{model, mmproj} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		llm     string
+		opts    Options
+		want    []string
+		wantErr bool
+	}{
+		{name: "repo", llm: "unsloth/gemma-3-1b-it-GGUF:Q8_0", want: []string{"-hf", "unsloth/gemma-3-1b-it-GGUF:Q8_0"}},
+		{name: "local", llm: model, want: []string{"-m", model}},
+		{name: "missing local", llm: filepath.Join(dir, "missing.gguf"), wantErr: true},
+		{
+			name: "extras",
+			llm:  model,
+			opts: Options{MMProj: mmproj, Draft: "unsloth/gemma-3-270m-it-GGUF"},
+			want: []string{"-m", model, "--mmproj", mmproj, "-hfd", "unsloth/gemma-3-270m-it-GGUF"},
+		},
+		{name: "local draft", llm: "unsloth/gemma-3-1b-it-GGUF", opts: Options{Draft: model}, want: []string{"-hf", "unsloth/gemma-3-1b-it-GGUF", "-md", model}},
+		{name: "missing mmproj", llm: model, opts: Options{MMProj: filepath.Join(dir, "nope.gguf")}, wantErr: true},
+		{name: "bad draft", llm: model, opts: Options{Draft: "draft"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := modelArgs(tt.llm, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("modelArgs error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("modelArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package llama
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Options configure how llama-server is launched. Zero values leave
+// llama-server's own defaults in place.
+type Options struct {
+	// Binary is the llama-server executable, looked up in PATH if it has no
+	// path separator. Defaults to "llama-server".
+	Binary string
+
+	// MMProj is a local multimodal projector GGUF (--mmproj). Repos with a
+	// projector get theirs downloaded automatically.
+	MMProj string
+	// Draft is a small model for speculative decoding, in any form ParseModelRef
+	// accepts (-md or -hfd).
+	Draft string
+
+	CtxSize   int    // --ctx-size, 0 uses the model's training context
+	Threads   int    // --threads
+	BatchSize int    // --batch-size
+	Parallel  int    // --parallel, number of slots
+	GPULayers string // --n-gpu-layers: a number, "auto" or "all"
+	MLock     bool   // --mlock, keep the model in RAM
+	FlashAttn string // --flash-attn: "on", "off" or "auto"
+	// UBatchSize is the physical batch size (--ubatch-size). Embeddings need
+	// the whole input in one, so it bounds the texts /embedding accepts.
+	UBatchSize int
+
+	// Embeddings serves /embedding (--embeddings), for embedding models.
+	Embeddings bool
+
+	// LoRA adapters are loaded with the model (--lora) but only applied to
+	// the requests that name them, see InferenceReq.LoRA.
+	LoRA []LoRA
+
+	// ExtraArgs are appended verbatim after everything else.
+	ExtraArgs []string
+
+	// Log, if set, receives llama-server's stdout and stderr line by line,
+	// minus download progress bar redraws.
+	Log io.Writer
+}
+
+// reservedArgs are set by Server itself and can't be overridden through
+// ExtraArgs without breaking health checks or model loading.
+var reservedArgs = []string{"--host", "--port", "-m", "--model", "-hf", "-hfr", "--hf-repo"}
+
+// Validate reports the first invalid option.
+func (o Options) Validate() error {
+	for _, opt := range o.counts() {
+		if opt.n < 0 {
+			return fmt.Errorf("invalid %s %d: must not be negative", opt.flag[2:], opt.n)
+		}
+	}
+	switch o.GPULayers {
+	case "", "auto", "all":
+	default:
+		if n, err := strconv.Atoi(o.GPULayers); err != nil || n < 0 {
+			return fmt.Errorf("invalid n-gpu-layers %q: want a number, \"auto\" or \"all\"", o.GPULayers)
+		}
+	}
+	switch o.FlashAttn {
+	case "", "on", "off", "auto":
+	default:
+		return fmt.Errorf("invalid flash-attn %q: want on, off or auto", o.FlashAttn)
+	}
+	names := map[string]bool{}
+	for _, a := range o.LoRA {
+		if a.Name == "" || a.Path == "" {
+			return fmt.Errorf("invalid LoRA adapter %q=%q: want a name and a path", a.Name, a.Path)
+		}
+		if names[a.Name] {
+			return fmt.Errorf("duplicate LoRA adapter %q", a.Name)
+		}
+		names[a.Name] = true
+	}
+	for _, arg := range o.ExtraArgs {
+		name, _, _ := strings.Cut(arg, "=")
+		for _, reserved := range reservedArgs {
+			if name == reserved {
+				return fmt.Errorf("extra llama-server argument %s is managed by nomodit", reserved)
+			}
+		}
+	}
+	return nil
+}
+
+func (o Options) binary() string {
+	if o.Binary == "" {
+		return "llama-server"
+	}
+	return o.Binary
+}
+
+type countOption struct {
+	flag string
+	n    int
+}
+
+func (o Options) counts() []countOption {
+	return []countOption{
+		{"--ctx-size", o.CtxSize},
+		{"--threads", o.Threads},
+		{"--batch-size", o.BatchSize},
+		{"--ubatch-size", o.UBatchSize},
+		{"--parallel", o.Parallel},
+	}
+}
+
+// args returns the llama-server flags for everything but the model.
+func (o Options) args() []string {
+	var args []string
+	for _, opt := range o.counts() {
+		if opt.n > 0 {
+			args = append(args, opt.flag, strconv.Itoa(opt.n))
+		}
+	}
+	if o.GPULayers != "" {
+		args = append(args, "--n-gpu-layers", o.GPULayers)
+	}
+	if o.MLock {
+		args = append(args, "--mlock")
+	}
+	if o.FlashAttn != "" {
+		args = append(args, "--flash-attn", o.FlashAttn)
+	}
+	if o.Embeddings {
+		args = append(args, "--embeddings")
+	}
+	for _, a := range o.LoRA {
+		args = append(args, "--lora", a.Path)
+	}
+	if len(o.LoRA) > 0 {
+		// scales start at 0, requests switch them on, see Server.useLoRA
+		args = append(args, "--lora-init-without-apply")
+	}
+	return append(args, o.ExtraArgs...)
+}
+
+// commandLine renders args the way a shell would need them.
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?[]{}()<>|&;#~") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,73 @@
+package llama
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts    Options
+		wantErr string
+	}{
+		{opts: Options{}},
+		{opts: Options{CtxSize: 4096, Threads: 8, GPULayers: "0", FlashAttn: "auto", ExtraArgs: []string{"--no-webui"}}},
+		{opts: Options{GPULayers: "all"}},
+		{opts: Options{Threads: -1}, wantErr: "invalid threads -1"},
+		{opts: Options{GPULayers: "many"}, wantErr: "invalid n-gpu-layers"},
+		{opts: Options{FlashAttn: "yes"}, wantErr: "invalid flash-attn"},
+		{opts: Options{ExtraArgs: []string{"--port=9000"}}, wantErr: "--port is managed by nomodit"},
+		{opts: Options{ExtraArgs: []string{"-hf", "other/model"}}, wantErr: "-hf is managed by nomodit"},
+		{opts: Options{LoRA: []LoRA{{"grammar", "gec.gguf"}, {"simplify", "simple.gguf"}}}},
+		{opts: Options{LoRA: []LoRA{{"grammar", "a.gguf"}, {"grammar", "b.gguf"}}}, wantErr: "duplicate LoRA adapter"},
+		{opts: Options{LoRA: []LoRA{{"grammar", ""}}}, wantErr: "invalid LoRA adapter"},
+	}
+	for _, tt := range tests {
+		err := tt.opts.Validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("Validate(%+v) = %v", tt.opts, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Validate(%+v) = %v, want %q", tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOptionsArgs(t *testing.T) {
+	opts := Options{
+		CtxSize:    8192,
+		Threads:    4,
+		BatchSize:  512,
+		UBatchSize: 256,
+		Parallel:   2,
+		GPULayers:  "0",
+		MLock:      true,
+		FlashAttn:  "off",
+		Embeddings: true,
+		LoRA:       []LoRA{{"grammar", "gec.gguf"}, {"simplify", "simple.gguf"}},
+		ExtraArgs:  []string{"--no-webui"},
+	}
+	want := []string{
+		"--ctx-size", "8192", "--threads", "4", "--batch-size", "512", "--ubatch-size", "256", "--parallel", "2",
+		"--n-gpu-layers", "0", "--mlock", "--flash-attn", "off", "--embeddings",
+		"--lora", "gec.gguf", "--lora", "simple.gguf", "--lora-init-without-apply", "--no-webui",
+	}
+	if got := opts.args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("args() = %q, want %q", got, want)
+	}
+	if got := (Options{}).args(); len(got) != 0 {
+		t.Errorf("zero Options give args %q, want none", got)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	got := commandLine([]string{"/usr/bin/llama-server", "-m", "/models/my model.gguf", "--port", "8080"})
+	want := `/usr/bin/llama-server -m "/models/my model.gguf" --port 8080`
+	if got != want {
+		t.Errorf("commandLine = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package llama
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// TokenProb is a generated token and the probability the model gave it,
+// before sampling.
+type TokenProb struct {
+	Token string
+	Prob  float64
+}
+
+// tokenProb is one token of llama-server's completion_probabilities, or of
+// the chat API's logprobs.content. Depending on the version and on
+// post_sampling_probs, it carries the chosen token's logprob or prob, or, in
+// servers before b4681, the top candidates the chosen token is one of.
+type tokenProb struct {
+	Token   string   `json:"token"`
+	Logprob *float64 `json:"logprob"`
+	Prob    *float64 `json:"prob"`
+	Content string   `json:"content"`
+	Probs   []struct {
+		TokStr string  `json:"tok_str"`
+		Prob   float64 `json:"prob"`
+	} `json:"probs"`
+}
+
+func (t tokenProb) decode() TokenProb {
+	switch {
+	case t.Logprob != nil:
+		return TokenProb{Token: t.Token, Prob: math.Exp(*t.Logprob)}
+	case t.Prob != nil:
+		return TokenProb{Token: t.Token, Prob: *t.Prob}
+	}
+	p := TokenProb{Token: t.Content}
+	for _, candidate := range t.Probs {
+		if candidate.TokStr == t.Content {
+			p.Prob = candidate.Prob
+			break
+		}
+	}
+	return p
+}
+
+// setProbs attaches probs to resp once the reasoning has been split off.
+// Events that only hold reasoning get none, so that Probs follow Content.
+func (resp *InferenceResp) setProbs(probs []tokenProb) {
+	if len(probs) == 0 || (resp.Content == "" && resp.Reasoning != "") {
+		return
+	}
+	resp.Probs = make([]TokenProb, len(probs))
+	for i, p := range probs {
+		resp.Probs[i] = p.decode()
+	}
+}
+
+// Confidence returns, for each byte of text, the probability of the token it
+// came from, or 1 where no token could be matched. text is the answer the
+// tokens produced, possibly trimmed, stitched from parts or extracted from
+// JSON, so the tokens are matched in order wherever they continue it. Tokens
+// that don't (JSON syntax, escapes, reasoning) are skipped, as is whitespace
+// the tokens don't account for.
+func Confidence(text string, probs []TokenProb) []float64 {
+	conf := make([]float64, len(text))
+	for i := range conf {
+		conf[i] = 1
+	}
+	pos := 0
+	for _, p := range probs {
+		start, n := matchToken(text, pos, p.Token)
+		for i := start; i < start+n; i++ {
+			conf[i] = p.Prob
+		}
+		pos = start + n
+	}
+	return conf
+}
+
+// matchToken returns where tok continues text from pos, and how many bytes
+// of it match. A token may be found after whitespace of text, or only in
+// part, if the rest is syntax such as the `":"` before the answer. It returns
+// n = 0 if tok doesn't continue text.
+func matchToken(text string, pos int, tok string) (start, n int) {
+	if tok == "" {
+		return pos, 0
+	}
+	for i := range tok { // longest suffix first, at rune boundaries
+		if i > 0 && !isSyntax(tok[:i]) {
+			break
+		}
+		if strings.HasPrefix(text[pos:], tok[i:]) {
+			return pos, len(tok) - i
+		}
+	}
+	// or what comes next, followed by syntax, as in `."}`
+	k := 0
+	for k < len(tok) && pos+k < len(text) && tok[k] == text[pos+k] {
+		k++
+	}
+	if k > 0 && isSyntax(tok[k:]) {
+		return pos, k
+	}
+	for start = pos; start < len(text) && unicode.IsSpace(rune(text[start])); start++ {
+		if strings.HasPrefix(text[start+1:], tok) {
+			return start + 1, len(tok)
+		}
+	}
+	return pos, 0
+}
+
+// jsonEscape matches the escape sequences of JSON strings.
+var jsonEscape = regexp.MustCompile(`\\(u[0-9a-fA-F]{4}|.)`)
+
+// isSyntax reports whether s has no letters or digits, other than in JSON
+// escape sequences.
+func isSyntax(s string) bool {
+	return !strings.ContainsFunc(jsonEscape.ReplaceAllString(s, ""), func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	})
+}
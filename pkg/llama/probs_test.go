@@ -0,0 +1,128 @@
+package llama
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestTokenProbFormats(t *testing.T) {
+	tests := []struct {
+		name, data string
+		want       TokenProb
+	}{
+		{"logprob", `{"id":1,"token":" went","logprob":-0.6931471805599453,"top_logprobs":[]}`, TokenProb{" went", 0.5}},
+		{"post sampling", `{"id":1,"token":" went","prob":0.25,"top_probs":[]}`, TokenProb{" went", 0.25}},
+		{"before b4681", `{"content":" went","probs":[{"tok_str":" gone","prob":0.6},{"tok_str":" went","prob":0.3}]}`, TokenProb{" went", 0.3}},
+	}
+	for _, tt := range tests {
+		var p tokenProb
+		if err := json.Unmarshal([]byte(tt.data), &p); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := p.decode()
+		if got.Token != tt.want.Token || math.Abs(got.Prob-tt.want.Prob) > 1e-9 {
+			t.Errorf("%s: decode() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProbsStreamed(t *testing.T) {
+	cfg := llamatest.Config{Tokens: []string{"I", " went", "."}, Probs: []float64{0.9, 0.4}}
+	want := []TokenProb{{"I", 0.9}, {" went", 0.4}, {".", 1}}
+	s, _ := newFakeBackedServer(t, cfg, true)
+
+	streams := map[string]func() (<-chan InferenceResp, error){
+		"completion": func() (<-chan InferenceResp, error) {
+			return s.Inference(context.Background(), InferenceReq{Prompt: "Fix: I has went.", NProbs: 1})
+		},
+		"chat": func() (<-chan InferenceResp, error) {
+			return s.Chat(context.Background(), ChatReq{Messages: []ChatMessage{{Role: "user", Content: "Fix: I has went."}}, Logprobs: true})
+		},
+	}
+	for name, start := range streams {
+		events, err := start()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var got []TokenProb
+		for event := range events {
+			if event.Err != nil {
+				t.Fatalf("%s: %v", name, event.Err)
+			}
+			got = append(got, event.Probs...)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: probs = %+v, want %+v", name, got, want)
+		}
+		for i := range want {
+			if got[i].Token != want[i].Token || math.Abs(got[i].Prob-want[i].Prob) > 1e-9 {
+				t.Errorf("%s: probs = %+v, want %+v", name, got, want)
+				break
+			}
+		}
+	}
+
+	events, err := s.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event := range events {
+		if event.Probs != nil {
+			t.Errorf("got probs without asking for them: %+v", event.Probs)
+		}
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	tests := []struct {
+		name, text string
+		probs      []TokenProb
+		want       string // per byte: '-' below 0.5, '+' above, '.' unmatched
+	}{
+		{
+			name:  "plain",
+			text:  "I went home.",
+			probs: []TokenProb{{"I", 0.9}, {" went", 0.3}, {" home", 0.8}, {".", 0.7}},
+			want:  "+-----++++++",
+		},
+		{
+			name:  "trimmed and stitched",
+			text:  "I went.\n\nHome.",
+			probs: []TokenProb{{" I", 0.9}, {" went", 0.3}, {".", 0.9}, {"Home", 0.2}, {".", 0.9}},
+			want:  "+-----+..----+",
+		},
+		{
+			name:  "structured",
+			text:  "I went.\nHome",
+			probs: []TokenProb{{`{"`, 1}, {"edited", 1}, {`_text`, 1}, {`":"`, 1}, {`I`, 0.9}, {" went", 0.3}, {`.\n`, 0.9}, {"Home", 0.2}, {`","`, 1}, {"edits", 1}},
+			want:  "+-----+.----",
+		},
+		{
+			name:  "syntax around",
+			text:  "Hi.",
+			probs: []TokenProb{{`":"Hi`, 0.3}, {`."}`, 0.9}},
+			want:  "--+",
+		},
+	}
+	for _, tt := range tests {
+		conf := Confidence(tt.text, tt.probs)
+		got := make([]byte, len(conf))
+		for i, c := range conf {
+			switch {
+			case c == 1:
+				got[i] = '.'
+			case c < 0.5:
+				got[i] = '-'
+			default:
+				got[i] = '+'
+			}
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: Confidence = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
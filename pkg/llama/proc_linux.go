@@ -0,0 +1,9 @@
+package llama
+
+import "syscall"
+
+// setParentDeathSignal has the kernel kill llama-server if nomodit dies without
+// getting a chance to run Stop (SIGKILL, OOM killer, ...).
+func setParentDeathSignal(attr *syscall.SysProcAttr) {
+	attr.Pdeathsig = syscall.SIGKILL
+}
@@ -0,0 +1,8 @@
+//go:build unix && !linux
+
+package llama
+
+import "syscall"
+
+// setParentDeathSignal is a no-op: only Linux supports parent death signals.
+func setParentDeathSignal(attr *syscall.SysProcAttr) {}
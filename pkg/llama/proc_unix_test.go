@@ -0,0 +1,102 @@
+//go:build unix
+
+package llama
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStopTerminates(t *testing.T) {
+	s := &Server{}
+	if err := s.launch(exec.Command("sleep", "30")); err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+
+	start := time.Now()
+	s.Stop()
+	if elapsed := time.Since(start); elapsed > stopTimeout {
+		t.Errorf("Stop took %v, SIGTERM should have been enough", elapsed)
+	}
+	if err := s.Wait(); err == nil {
+		t.Error("Wait returned nil for a terminated process")
+	}
+	s.Stop() // second call must be a no-op
+}
+
+func TestStopKillsAfterTimeout(t *testing.T) {
+	defer func(d time.Duration) { stopTimeout = d }(stopTimeout)
+	stopTimeout = 100 * time.Millisecond
+
+	s := &Server{}
+	// the child shell ignores SIGTERM and spawns a grandchild in the same group
+	if err := s.launch(exec.Command("sh", "-c", `trap "" TERM; sleep 30 & wait`)); err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the trap get installed
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return after escalating to SIGKILL")
+	}
+}
+
+func TestStatusUpdatesProcessExit(t *testing.T) {
+	s := &Server{isModelCached: true, baseURL: "http://127.0.0.1:1"}
+	if err := s.launch(exec.Command("sh", "-c", "exit 3")); err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+
+	statuses := collectStatuses(t, s)
+	last := statuses[len(statuses)-1]
+	if !last.IsError || last.Message != "llama-server exited unexpectedly: exit status 3" {
+		t.Errorf("last status = %+v, want exit error", last)
+	}
+}
+
+func TestStartLaunchOptions(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "llama-server")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexec sleep 30\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	model := filepath.Join(dir, "nomodit-Q4_K_M.gguf")
+	if err := os.WriteFile(model, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServerWithOptions(model, "", Options{Binary: binary, CtxSize: 2048, ExtraArgs: []string{"--no-webui"}})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(s.Stop)
+
+	want := fmt.Sprintf("%s -m %s --host 127.0.0.1 --port %s --ctx-size 2048 --no-webui", binary, model, s.Port())
+	if got := s.CommandLine(); got != want {
+		t.Errorf("CommandLine() = %s, want %s", got, want)
+	}
+	if !s.isModelCached {
+		t.Error("a local model file should count as cached")
+	}
+}
+
+func TestStartInvalidOptions(t *testing.T) {
+	s := NewServerWithOptions("unsloth/gemma-3-1b-it-GGUF", "", Options{FlashAttn: "sometimes"})
+	if err := s.Start(); err == nil {
+		s.Stop()
+		t.Fatal("Start accepted an invalid flash-attn value")
+	}
+	if s.CommandLine() != "" {
+		t.Errorf("CommandLine() = %q before launch", s.CommandLine())
+	}
+}
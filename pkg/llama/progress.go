@@ -0,0 +1,234 @@
+package llama
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloadPollInterval is how often the size of a partial download is
+// sampled, a variable so tests can shorten it.
+var downloadPollInterval = time.Second
+
+// Progress describes a model download in flight.
+type Progress struct {
+	Bytes int64         // downloaded so far
+	Total int64         // size of the file, 0 if unknown
+	Rate  float64       // smoothed download speed in bytes per second
+	ETA   time.Duration // time left at Rate, 0 if unknown
+}
+
+// Fraction returns how much of the file is downloaded, from 0 to 1, or -1 if
+// the total size is unknown.
+func (p Progress) Fraction() float64 {
+	if p.Total <= 0 {
+		return -1
+	}
+	return min(float64(p.Bytes)/float64(p.Total), 1)
+}
+
+// String renders the numbers, e.g. "312.0 MiB / 806.0 MiB · 12.3 MiB/s · ETA 40s".
+func (p Progress) String() string {
+	parts := []string{FormatBytes(p.Bytes)}
+	if p.Total > 0 {
+		parts[0] += " / " + FormatBytes(p.Total)
+	}
+	if p.Rate > 0 {
+		parts = append(parts, FormatBytes(int64(p.Rate))+"/s")
+	}
+	if p.ETA > 0 {
+		parts = append(parts, "ETA "+p.ETA.Round(time.Second).String())
+	}
+	return strings.Join(parts, " · ")
+}
+
+// Bar renders a progress bar width cells wide followed by the percentage, or
+// just an empty bar while the total size is unknown.
+func (p Progress) Bar(width int) string {
+	f := p.Fraction()
+	if f < 0 {
+		return "[" + strings.Repeat("░", width) + "]"
+	}
+	done := int(f * float64(width))
+	return fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("█", done), strings.Repeat("░", width-done), f*100)
+}
+
+// downloadTracker combines what llama-server prints about a download with the
+// size of the partial file on disk into Progress updates.
+type downloadTracker struct {
+	mu    sync.Mutex
+	path  string // partial file named in llama-server's output
+	bytes int64  // last size llama-server reported
+	total int64
+
+	// rate smoothing state for sample
+	lastBytes int64
+	lastAt    time.Time
+	rate      float64
+}
+
+var (
+	// trying to download model from <url> to <path>.downloadInProgress (...)
+	downloadPathRe = regexp.MustCompile(`to (\S+` + regexp.QuoteMeta(partialFileSuffix) + `)`)
+	// llama.cpp's own bar: [=====>    ]  45%  (360 MB / 800 MB)
+	llamaProgressRe = regexp.MustCompile(`\]\s*\d+%\s*\((\d+) MB / (\d+) MB\)`)
+	// curl's meter: % Total  % Received ..., e.g. " 45  800M   45  360M    0     0  50.2M ..."
+	curlProgressRe = regexp.MustCompile(`^\s*\d{1,3}\s+(\d+(?:\.\d+)?[kMGT]?)\s+\d{1,3}\s+(\d+(?:\.\d+)?[kMGT]?)\s`)
+)
+
+// observe picks download details out of one line of llama-server output and
+// reports whether it was a progress bar update, which is of no further use.
+func (t *downloadTracker) observe(line string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if m := downloadPathRe.FindStringSubmatch(line); m != nil {
+		t.path = m[1]
+		return false // still announces the download, see parseStderr
+	}
+	if m := llamaProgressRe.FindStringSubmatch(line); m != nil {
+		cur, _ := strconv.ParseInt(m[1], 10, 64)
+		total, _ := strconv.ParseInt(m[2], 10, 64)
+		t.bytes, t.total = cur<<20, total<<20
+		return true
+	}
+	if m := curlProgressRe.FindStringSubmatch(line); m != nil {
+		total, ok1 := parseCurlSize(m[1])
+		cur, ok2 := parseCurlSize(m[2])
+		if ok1 && ok2 {
+			t.bytes, t.total = cur, total
+			return true
+		}
+	}
+	return false
+}
+
+// parseCurlSize parses curl's abbreviated sizes such as "806M" or "1.2G".
+func parseCurlSize(s string) (int64, bool) {
+	shift := 0
+	switch s[len(s)-1] {
+	case 'k':
+		shift = 10
+	case 'M':
+		shift = 20
+	case 'G':
+		shift = 30
+	case 'T':
+		shift = 40
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int64(n * float64(int64(1)<<shift)), true
+}
+
+// sample returns the current progress, measuring the partial file (the named
+// one, or any partial file of llm in cacheDir) when it is ahead of what
+// llama-server last printed. ok is false while no download has been seen.
+func (t *downloadTracker) sample(llm, cacheDir string, now time.Time) (p Progress, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, total := t.bytes, t.total
+	if size, found := partialSize(t.path, llm, cacheDir); found && size > n {
+		n = size
+	} else if !found && n == 0 {
+		return Progress{}, false
+	}
+
+	if !t.lastAt.IsZero() {
+		if dt := now.Sub(t.lastAt).Seconds(); dt > 0 {
+			inst := float64(n-t.lastBytes) / dt
+			if t.rate == 0 {
+				t.rate = inst
+			} else {
+				t.rate = 0.3*inst + 0.7*t.rate
+			}
+		}
+	}
+	t.lastBytes, t.lastAt = n, now
+
+	p = Progress{Bytes: n, Total: total, Rate: max(t.rate, 0)}
+	if p.Total > p.Bytes && p.Rate > 0 {
+		p.ETA = time.Duration(float64(p.Total-p.Bytes) / p.Rate * float64(time.Second))
+	}
+	return p, true
+}
+
+// partialSize returns the size of the download in progress: path if
+// llama-server named it, otherwise the partial files of llm in cacheDir.
+func partialSize(path, llm, cacheDir string) (int64, bool) {
+	if path != "" {
+		if info, err := os.Stat(path); err == nil {
+			return info.Size(), true
+		}
+		return 0, false
+	}
+	ref, err := ParseModelRef(llm)
+	if err != nil || ref.Local() || cacheDir == "" {
+		return 0, false
+	}
+	models, err := listCachedModels(cacheDir)
+	if err != nil {
+		return 0, false
+	}
+	var size int64
+	found := false
+	for _, m := range modelsOf(models, ref) {
+		if m.Downloading {
+			size += m.Size
+			found = true
+		}
+	}
+	return size, found
+}
+
+// monitorDownload reports download progress every downloadPollInterval until
+// ctx is cancelled.
+func (s *Server) monitorDownload(ctx context.Context, statusChan chan<- ServerStatus) {
+	cacheDir, _ := getCacheDir()
+	ticker := time.NewTicker(downloadPollInterval)
+	defer ticker.Stop()
+	var last int64 = -1
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p, ok := s.download.sample(s.llm, cacheDir, now)
+			if !ok || p.Bytes == last {
+				continue
+			}
+			last = p.Bytes
+			status := ServerStatus{Message: fmt.Sprintf("Downloading model '%s'", s.llm), Progress: &p}
+			select {
+			case statusChan <- status:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// scanOutputLines is bufio.ScanLines that also splits on the carriage returns
+// progress bars use to redraw themselves.
+func scanOutputLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
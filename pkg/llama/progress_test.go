@@ -0,0 +1,110 @@
+package llama
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestDownloadTrackerObserve(t *testing.T) {
+	tests := []struct {
+		line         string
+		consumed     bool
+		bytes, total int64
+	}{
+		{line: "common_download_file_single: trying to download model from https://hf.co/x to /c/x.gguf.downloadInProgress (etag:...)"},
+		{line: "[=========>          ]  45%  (360 MB / 800 MB) ", consumed: true, bytes: 360 << 20, total: 800 << 20},
+		{line: " 45  806M   45  363M    0     0  50.2M      0  0:00:16  0:00:07  0:00:09 51.0M", consumed: true, bytes: 363 << 20, total: 806 << 20},
+		{line: "100 2.5G  100 2.5G    0     0  60.1M      0  0:00:42  0:00:42 --:--:-- 60.0M", consumed: true, bytes: 5 << 29, total: 5 << 29},
+		{line: "load_model: loading model 'x.gguf'"},
+	}
+	for _, tt := range tests {
+		var tracker downloadTracker
+		if got := tracker.observe(tt.line); got != tt.consumed {
+			t.Errorf("observe(%q) = %v, want %v", tt.line, got, tt.consumed)
+		}
+		if tracker.bytes != tt.bytes || tracker.total != tt.total {
+			t.Errorf("observe(%q): bytes %d / %d, want %d / %d", tt.line, tracker.bytes, tracker.total, tt.bytes, tt.total)
+		}
+	}
+
+	var tracker downloadTracker
+	tracker.observe("trying to download model from https://hf.co/x to /c/x.gguf.downloadInProgress (etag:...)")
+	if tracker.path != "/c/x.gguf.downloadInProgress" {
+		t.Errorf("path = %q", tracker.path)
+	}
+}
+
+func TestDownloadTrackerSample(t *testing.T) {
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "unsloth_gemma-3-1b-it-GGUF_gemma-3-1b-it-Q4_K_M.gguf"+partialFileSuffix)
+	llm := "unsloth/gemma-3-1b-it-GGUF"
+
+	var tracker downloadTracker
+	start := time.Now()
+	if _, ok := tracker.sample(llm, dir, start); ok {
+		t.Fatal("sample reported progress before any download started")
+	}
+
+	// total from llama-server's output, bytes from the growing file
+	tracker.observe("[=>      ]  10%  (10 MB / 100 MB)")
+	if err := os.WriteFile(partial, make([]byte, 20<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := tracker.sample(llm, dir, start)
+	if !ok || p.Bytes != 20<<20 || p.Total != 100<<20 || p.Rate != 0 {
+		t.Fatalf("first sample = %+v, %v", p, ok)
+	}
+
+	if err := os.WriteFile(partial, make([]byte, 30<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, _ = tracker.sample(llm, dir, start.Add(time.Second))
+	if p.Rate != 10<<20 || p.ETA != 7*time.Second {
+		t.Errorf("second sample = %+v, want 10 MiB/s and 7s left", p)
+	}
+	if got, want := p.String(), "30.0 MiB / 100.0 MiB · 10.0 MiB/s · ETA 7s"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got, want := p.Bar(10), "[███░░░░░░░]  30%"; got != want {
+		t.Errorf("Bar(10) = %q, want %q", got, want)
+	}
+}
+
+func TestScanOutputLines(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("start\n[=> ] 10%\r[==>] 20%\rdone"))
+	scanner.Split(scanOutputLines)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if got := strings.Join(lines, "|"); got != "start|[=> ] 10%|[==>] 20%|done" {
+		t.Errorf("lines = %q", got)
+	}
+}
+
+func TestStatusUpdatesDownloadProgress(t *testing.T) {
+	t.Setenv("LLAMA_CACHE", t.TempDir())
+	s, _ := newFakeBackedServer(t, llamatest.Config{
+		LoadingPolls: 10,
+		Stderr:       []string{"[=========>          ]  45%  (360 MB / 800 MB) "},
+	}, false)
+
+	var progress *Progress
+	for _, status := range collectStatuses(t, s) {
+		if status.Progress != nil {
+			progress = status.Progress
+		}
+	}
+	if progress == nil {
+		t.Fatal("no download progress reported")
+	}
+	if progress.Bytes != 360<<20 || progress.Total != 800<<20 {
+		t.Errorf("progress = %+v, want 360 of 800 MiB", progress)
+	}
+}
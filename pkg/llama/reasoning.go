@@ -0,0 +1,101 @@
+package llama
+
+import "strings"
+
+// reasoningTags are the open/close pairs models use to wrap their reasoning
+// before the final answer.
+var reasoningTags = [][2]string{
+	{"<think>", "</think>"},
+	{"<reasoning>", "</reasoning>"},
+}
+
+// reasoningSplitter separates reasoning blocks from the answer in a stream of
+// content chunks. Tags may be split across chunks, so a possible partial tag
+// at the end of a chunk is held back until the next one arrives.
+type reasoningSplitter struct {
+	closeTag string // non-empty while inside a reasoning block
+	pending  string
+}
+
+func (r *reasoningSplitter) split(chunk string) (answer, reasoning string) {
+	var a, rs strings.Builder
+	buf := r.pending + chunk
+	r.pending = ""
+	for buf != "" {
+		if r.closeTag == "" {
+			i, tag := -1, [2]string{}
+			for _, t := range reasoningTags {
+				if j := strings.Index(buf, t[0]); j >= 0 && (i < 0 || j < i) {
+					i, tag = j, t
+				}
+			}
+			if i < 0 {
+				keep := partialSuffix(buf, openTags())
+				a.WriteString(buf[:len(buf)-keep])
+				r.pending = buf[len(buf)-keep:]
+				break
+			}
+			a.WriteString(buf[:i])
+			r.closeTag = tag[1]
+			buf = buf[i+len(tag[0]):]
+			continue
+		}
+		i := strings.Index(buf, r.closeTag)
+		if i < 0 {
+			keep := partialSuffix(buf, []string{r.closeTag})
+			rs.WriteString(buf[:len(buf)-keep])
+			r.pending = buf[len(buf)-keep:]
+			break
+		}
+		rs.WriteString(buf[:i])
+		buf = buf[i+len(r.closeTag):]
+		r.closeTag = ""
+	}
+	return a.String(), rs.String()
+}
+
+// flush returns whatever was held back once the stream is over.
+func (r *reasoningSplitter) flush() (answer, reasoning string) {
+	pending := r.pending
+	r.pending = ""
+	if r.closeTag != "" {
+		return "", pending
+	}
+	return pending, ""
+}
+
+func openTags() []string {
+	tags := make([]string, len(reasoningTags))
+	for i, t := range reasoningTags {
+		tags[i] = t[0]
+	}
+	return tags
+}
+
+// partialSuffix returns the length of the longest suffix of s that is a
+// proper prefix of one of tags.
+func partialSuffix(s string, tags []string) int {
+	longest := 0
+	for _, tag := range tags {
+		for n := min(len(tag)-1, len(s)); n > longest; n-- {
+			if strings.HasSuffix(s, tag[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// apply moves any reasoning found in resp.Content into resp.Reasoning,
+// flushing held back text on the final event.
+func (r *reasoningSplitter) apply(resp *InferenceResp) {
+	answer, reasoning := r.split(resp.Content)
+	if resp.Stop {
+		a, rs := r.flush()
+		answer += a
+		reasoning += rs
+	}
+	resp.Content = answer
+	resp.Reasoning += reasoning
+}
@@ -0,0 +1,76 @@
+package llama
+
+import (
+	"context"
+	"testing"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestReasoningSplitter(t *testing.T) {
+	tests := []struct {
+		in, answer, reasoning string
+	}{
+		{"no reasoning here", "no reasoning here", ""},
+		{"<think>has went is wrong</think>I went.", "I went.", "has went is wrong"},
+		{"<reasoning>step 1\nstep 2</reasoning>\nDone", "\nDone", "step 1\nstep 2"},
+		{"a < b <th", "a < b <th", ""},
+		{"<think>unterminated", "", "unterminated"},
+		{"x<think>a</think>y<reasoning>b</reasoning>z", "xyz", "ab"},
+	}
+	for _, tt := range tests {
+		// feed one byte at a time so every tag gets split across chunks
+		var r reasoningSplitter
+		var answer, reasoning string
+		for i := 0; i < len(tt.in); i++ {
+			resp := InferenceResp{Content: tt.in[i : i+1], Stop: i == len(tt.in)-1}
+			r.apply(&resp)
+			answer += resp.Content
+			reasoning += resp.Reasoning
+		}
+		if answer != tt.answer || reasoning != tt.reasoning {
+			t.Errorf("%q: got answer %q reasoning %q, want %q and %q", tt.in, answer, reasoning, tt.answer, tt.reasoning)
+		}
+	}
+}
+
+func TestInferenceReasoning(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{
+		Tokens: []string{"<thi", "nk>fix tense</th", "ink>", "I went."},
+	}, true)
+
+	respChan, err := s.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	var answer, reasoning string
+	for resp := range respChan {
+		answer += resp.Content
+		reasoning += resp.Reasoning
+	}
+	if answer != "I went." || reasoning != "fix tense" {
+		t.Errorf("got answer %q reasoning %q", answer, reasoning)
+	}
+}
+
+func TestChatReasoningContent(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{Events: []string{
+		`{"choices":[{"index":0,"delta":{"reasoning_content":"fix tense"},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"I went."},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`[DONE]`,
+	}}, true)
+
+	respChan, err := s.Chat(context.Background(), ChatReq{Messages: []ChatMessage{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	var answer, reasoning string
+	for resp := range respChan {
+		answer += resp.Content
+		reasoning += resp.Reasoning
+	}
+	if answer != "I went." || reasoning != "fix tense" {
+		t.Errorf("got answer %q reasoning %q", answer, reasoning)
+	}
+}
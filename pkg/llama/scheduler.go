@@ -0,0 +1,268 @@
+package llama
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// Priority orders requests waiting for a Scheduler slot.
+type Priority int
+
+const (
+	// PriorityInteractive is for someone waiting on the answer, the default.
+	PriorityInteractive Priority = iota
+	// PriorityBatch is for background work such as the chunks of a long
+	// document or a list of files, served when no interactive request waits.
+	PriorityBatch
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests a Scheduler queues with p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityOf returns the priority set by WithPriority, PriorityInteractive if
+// none was. Values out of range are clamped to the nearest priority.
+func PriorityOf(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return min(max(p, PriorityInteractive), PriorityBatch)
+}
+
+// errNoTokenizer is returned by a Scheduler whose backend can't tokenize.
+var errNoTokenizer = errors.New("backend does not support tokenizing")
+
+// Scheduler is a Backend that lets any number of callers share another one,
+// keeping at most one request per llama-server slot in flight so that none
+// of them sits in llama-server's own queue, where it can't be reordered.
+// Waiting requests are served by priority (see WithPriority), then in order.
+// A request holds its slot until its stream is closed.
+//
+// Each request is pinned to the slot it was given (id_slot). Requests with
+// the same Affinity go back to the slot that last served it, so the prompt
+// prefix they share is still in that slot's cache.
+type Scheduler struct {
+	Backend
+	slots int
+
+	mu       sync.Mutex
+	busy     []bool
+	affinity []string // Affinity of the last request each slot served
+	lastUsed []int    // tick each slot was last handed out, for LRU
+	tick     int
+	queues   [PriorityBatch + 1][]*waiter
+}
+
+// waiter is a request queued for a slot.
+type waiter struct {
+	affinity string
+	slot     chan int // receives the slot given to the request
+}
+
+var (
+	_ Backend   = (*Scheduler)(nil)
+	_ Tokenizer = (*Scheduler)(nil)
+)
+
+// NewScheduler returns a Scheduler for backend with the given number of
+// slots. With slots <= 0 it asks the backend (see Server.Slots) on the first
+// request, falling back to one.
+func NewScheduler(backend Backend, slots int) *Scheduler {
+	return &Scheduler{Backend: backend, slots: slots}
+}
+
+// Inference streams the completion for req once a slot is free.
+func (s *Scheduler) Inference(ctx context.Context, req InferenceReq) (<-chan InferenceResp, error) {
+	return s.schedule(ctx, req.Affinity, func(slot int) (<-chan InferenceResp, error) {
+		req.IDSlot = &slot
+		return s.Backend.Inference(ctx, req)
+	})
+}
+
+// Chat streams the reply to a conversation once a slot is free.
+func (s *Scheduler) Chat(ctx context.Context, req ChatReq) (<-chan InferenceResp, error) {
+	return s.schedule(ctx, req.Affinity, func(slot int) (<-chan InferenceResp, error) {
+		req.IDSlot = &slot
+		return s.Backend.Chat(ctx, req)
+	})
+}
+
+func (s *Scheduler) schedule(ctx context.Context, affinity string, call func(slot int) (<-chan InferenceResp, error)) (<-chan InferenceResp, error) {
+	slot, err := s.acquire(ctx, affinity)
+	if err != nil {
+		return nil, err
+	}
+	events, err := call(slot)
+	if err != nil {
+		s.release(slot)
+		return nil, err
+	}
+	out := make(chan InferenceResp, 100)
+	go func() {
+		defer close(out)
+		defer s.release(slot)
+		for event := range events {
+			if !send(ctx, out, event) {
+				// let the backend's stream wind down before giving the slot away
+				for range events {
+				}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// acquire waits for a free slot and returns it.
+func (s *Scheduler) acquire(ctx context.Context, affinity string) (int, error) {
+	s.mu.Lock()
+	if s.busy == nil {
+		if s.slots <= 0 {
+			s.slots = s.detectSlots(ctx)
+		}
+		s.busy = make([]bool, s.slots)
+		s.affinity = make([]string, s.slots)
+		s.lastUsed = make([]int, s.slots)
+	}
+	w := &waiter{affinity: affinity, slot: make(chan int, 1)}
+	p := PriorityOf(ctx)
+	s.queues[p] = append(s.queues[p], w)
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case slot := <-w.slot:
+		return slot, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, queued := range s.queues[p] {
+			if queued == w {
+				s.queues[p] = append(s.queues[p][:i], s.queues[p][i+1:]...)
+				return 0, ctx.Err()
+			}
+		}
+		// given a slot just as ctx ended, pass it on
+		s.busy[<-w.slot] = false
+		s.dispatch()
+		return 0, ctx.Err()
+	}
+}
+
+// release frees slot for the next waiter.
+func (s *Scheduler) release(slot int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy[slot] = false
+	s.dispatch()
+}
+
+// dispatch hands free slots to waiters, most urgent first. s.mu must be held.
+func (s *Scheduler) dispatch() {
+	for p := range s.queues {
+		for len(s.queues[p]) > 0 {
+			w := s.queues[p][0]
+			slot := s.pickSlot(w.affinity)
+			if slot < 0 {
+				return
+			}
+			s.queues[p] = s.queues[p][1:]
+			s.tick++
+			s.busy[slot], s.affinity[slot], s.lastUsed[slot] = true, w.affinity, s.tick
+			w.slot <- slot
+		}
+	}
+}
+
+// pickSlot returns the free slot that last served affinity, or else the
+// free slot unused the longest, keeping other prefixes cached. It returns -1
+// if all slots are busy.
+func (s *Scheduler) pickSlot(affinity string) int {
+	best := -1
+	for i, busy := range s.busy {
+		if busy {
+			continue
+		}
+		if affinity != "" && s.affinity[i] == affinity {
+			return i
+		}
+		if best < 0 || s.lastUsed[i] < s.lastUsed[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// detectSlots asks the backend how many slots it has. s.mu must be held,
+// which holds back other requests until the answer is in.
+func (s *Scheduler) detectSlots(ctx context.Context) int {
+	backend, ok := s.Backend.(interface {
+		Slots(context.Context) (int, error)
+	})
+	if !ok {
+		return 1
+	}
+	n, err := backend.Slots(ctx)
+	if err != nil {
+		log.Printf("could not get the slot count, running one request at a time: %v", err)
+		return 1
+	}
+	return n
+}
+
+// Tokenize tokenizes text on the backend, without waiting for a slot.
+func (s *Scheduler) Tokenize(ctx context.Context, text string) ([]int, error) {
+	tok, ok := s.Backend.(Tokenizer)
+	if !ok {
+		return nil, errNoTokenizer
+	}
+	return tok.Tokenize(ctx, text)
+}
+
+// Detokenize detokenizes tokens on the backend, without waiting for a slot.
+func (s *Scheduler) Detokenize(ctx context.Context, tokens []int) (string, error) {
+	tok, ok := s.Backend.(Tokenizer)
+	if !ok {
+		return "", errNoTokenizer
+	}
+	return tok.Detokenize(ctx, tokens)
+}
+
+// ContextSize returns the backend's context size.
+func (s *Scheduler) ContextSize(ctx context.Context) (int, error) {
+	tok, ok := s.Backend.(Tokenizer)
+	if !ok {
+		return 0, errNoTokenizer
+	}
+	return tok.ContextSize(ctx)
+}
+
+// Reasoning reports whether the backend's model reasons, false if the
+// backend can't tell.
+func (s *Scheduler) Reasoning(ctx context.Context) (bool, error) {
+	r, ok := s.Backend.(Reasoner)
+	if !ok {
+		return false, nil
+	}
+	return r.Reasoning(ctx)
+}
+
+// CommandLine returns the backend's llama-server command line, if it
+// launched one.
+func (s *Scheduler) CommandLine() string {
+	if backend, ok := s.Backend.(interface{ CommandLine() string }); ok {
+		return backend.CommandLine()
+	}
+	return ""
+}
+
+// Wait waits for the backend's llama-server to exit, if it launched one.
+func (s *Scheduler) Wait() error {
+	if backend, ok := s.Backend.(interface{ Wait() error }); ok {
+		return backend.Wait()
+	}
+	return nil
+}
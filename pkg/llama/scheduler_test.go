@@ -0,0 +1,296 @@
+package llama
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+// gatedBackend streams one event per request, then holds the stream open
+// until the test lets it finish.
+type gatedBackend struct {
+	Backend
+	mu       sync.Mutex
+	started  []string // prompts in the order they reached the backend
+	slots    []int    // id_slot of each of them
+	inFlight int
+	peak     int
+	finish   chan struct{}
+}
+
+func newGatedBackend() *gatedBackend {
+	return &gatedBackend{finish: make(chan struct{})}
+}
+
+func (b *gatedBackend) Inference(ctx context.Context, req InferenceReq) (<-chan InferenceResp, error) {
+	b.mu.Lock()
+	b.started = append(b.started, req.Prompt)
+	b.slots = append(b.slots, *req.IDSlot)
+	b.inFlight++
+	b.peak = max(b.peak, b.inFlight)
+	b.mu.Unlock()
+
+	out := make(chan InferenceResp)
+	go func() {
+		defer close(out)
+		defer func() {
+			b.mu.Lock()
+			b.inFlight--
+			b.mu.Unlock()
+		}()
+		select {
+		case <-b.finish:
+		case <-ctx.Done():
+			return
+		}
+		out <- InferenceResp{Content: req.Prompt, Stop: true}
+	}()
+	return out, nil
+}
+
+func (b *gatedBackend) startedPrompts() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.started...)
+}
+
+// waitFor polls until cond holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *Scheduler) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, q := range s.queues {
+		n += len(q)
+	}
+	return n
+}
+
+func TestSchedulerCapsInFlight(t *testing.T) {
+	backend := newGatedBackend()
+	s := NewScheduler(backend, 2)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, err := s.Inference(context.Background(), InferenceReq{Prompt: "x"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for range events {
+			}
+		}()
+	}
+	waitFor(t, "two requests in flight and three queued", func() bool {
+		return len(backend.startedPrompts()) == 2 && s.queued() == 3
+	})
+	close(backend.finish)
+	wg.Wait()
+
+	if n := len(backend.startedPrompts()); n != 5 {
+		t.Errorf("%d requests reached the backend, want 5", n)
+	}
+	if backend.peak != 2 {
+		t.Errorf("peak in flight = %d, want 2", backend.peak)
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	backend := newGatedBackend()
+	s := NewScheduler(backend, 1)
+	ctx := context.Background()
+
+	first, err := s.Inference(ctx, InferenceReq{Prompt: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan string, 3)
+	request := func(ctx context.Context, prompt string) {
+		events, err := s.Inference(ctx, InferenceReq{Prompt: prompt})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for event := range events {
+			results <- event.Content
+		}
+	}
+	go request(WithPriority(ctx, PriorityBatch), "batch 1")
+	waitFor(t, "batch 1 to queue", func() bool { return s.queued() == 1 })
+	go request(WithPriority(ctx, PriorityBatch), "batch 2")
+	waitFor(t, "batch 2 to queue", func() bool { return s.queued() == 2 })
+	go request(ctx, "interactive")
+	waitFor(t, "interactive to queue", func() bool { return s.queued() == 3 })
+
+	close(backend.finish)
+	for range first {
+	}
+	for range 3 {
+		<-results
+	}
+	got := backend.startedPrompts()
+	want := []string{"first", "interactive", "batch 1", "batch 2"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("served %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPriorityOf(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		ctx  context.Context
+		want Priority
+	}{
+		{ctx, PriorityInteractive},
+		{WithPriority(ctx, PriorityBatch), PriorityBatch},
+		{WithPriority(ctx, Priority(2)), PriorityBatch},
+		{WithPriority(ctx, Priority(-1)), PriorityInteractive},
+	}
+	for _, tt := range tests {
+		if got := PriorityOf(tt.ctx); got != tt.want {
+			t.Errorf("PriorityOf = %v, want %v", got, tt.want)
+		}
+	}
+
+	// out of range priorities are queued, not indexed out of bounds
+	backend := newGatedBackend()
+	s := NewScheduler(backend, 1)
+	events, err := s.Inference(WithPriority(ctx, Priority(7)), InferenceReq{Prompt: "odd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(backend.finish)
+	for range events {
+	}
+}
+
+func TestSchedulerCancelWhileQueued(t *testing.T) {
+	backend := newGatedBackend()
+	s := NewScheduler(backend, 1)
+
+	first, err := s.Inference(context.Background(), InferenceReq{Prompt: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := s.Inference(ctx, InferenceReq{Prompt: "abandoned"})
+		errc <- err
+	}()
+	waitFor(t, "the request to queue", func() bool { return s.queued() == 1 })
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("Inference error = %v, want context.Canceled", err)
+	}
+
+	close(backend.finish)
+	for range first {
+	}
+	// the slot is free again
+	events, err := s.Inference(context.Background(), InferenceReq{Prompt: "next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+	if got := backend.startedPrompts(); len(got) != 2 || got[1] != "next" {
+		t.Errorf("served %q, want [first next]", got)
+	}
+}
+
+func TestSchedulerDetectsSlots(t *testing.T) {
+	server, _ := newFakeBackedServer(t, llamatest.Config{Slots: 3, Tokens: []string{"ok"}}, true)
+	s := NewScheduler(server, 0)
+	events, err := s.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+	if s.slots != 3 {
+		t.Errorf("slots = %d, want 3 from /props", s.slots)
+	}
+	if n, err := s.ContextSize(context.Background()); err != nil || n != 4096 {
+		t.Errorf("ContextSize = %d, %v; want 4096", n, err)
+	}
+}
+
+func TestSchedulerAffinity(t *testing.T) {
+	backend := newGatedBackend()
+	close(backend.finish) // answer right away
+	s := NewScheduler(backend, 3)
+
+	for _, affinity := range []string{"fix grammar", "simplify", "fix grammar", "paraphrase", "simplify"} {
+		events, err := s.Inference(context.Background(), InferenceReq{Prompt: affinity, Affinity: affinity})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range events {
+		}
+	}
+	// repeated instructions return to their slot, new ones take an unused one
+	want := []int{0, 1, 0, 2, 1}
+	for i := range want {
+		if backend.slots[i] != want[i] {
+			t.Fatalf("slots = %v, want %v", backend.slots, want)
+		}
+	}
+}
+
+func TestSchedulerPromptCache(t *testing.T) {
+	server, _ := newFakeBackedServer(t, llamatest.Config{Slots: 2, Tokens: []string{"ok"}}, true)
+	s := NewScheduler(server, 0)
+
+	run := func(instruction, text string, cache bool) Timings {
+		t.Helper()
+		events, err := s.Inference(context.Background(), InferenceReq{
+			Prompt:      "Instruction: " + instruction + " Text: " + text,
+			CachePrompt: cache,
+			Affinity:    instruction,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var timings Timings
+		for event := range events {
+			if event.Result != nil {
+				timings = event.Result.Timings
+			}
+		}
+		return timings
+	}
+
+	if got := run("fix grammar", "one", true); got.CacheN != 0 {
+		t.Errorf("first request cached %d tokens, want 0", got.CacheN)
+	}
+	run("simplify", "two", true)
+	run("simplify", "three", true)
+	// back on the slot that holds "Instruction: fix grammar Text:"
+	got := run("fix grammar", "four", true)
+	if got.CacheN != 4 || got.PromptN != llamatest.PromptTokens-4 {
+		t.Errorf("cache_n = %d, prompt_n = %d; want 4 and %d", got.CacheN, got.PromptN, llamatest.PromptTokens-4)
+	}
+	if got := run("fix grammar", "five", false); got.CacheN != 0 {
+		t.Errorf("cache_n = %d without cache_prompt, want 0", got.CacheN)
+	}
+}
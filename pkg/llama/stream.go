@@ -0,0 +1,142 @@
+package llama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrIncompleteStream is reported when a response stream ends, or the
+// connection drops, before llama-server sent its final event.
+var ErrIncompleteStream = errors.New("stream ended before completion")
+
+// ServerError is an error payload from llama-server, either as the body of a
+// rejected request or as an event in the middle of a stream.
+type ServerError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("llama-server error %d (%s): %s", e.Code, e.Type, e.Message)
+}
+
+// MalformedEventError reports a stream event that could not be decoded.
+type MalformedEventError struct {
+	Data string
+	Err  error
+}
+
+func (e *MalformedEventError) Error() string {
+	return fmt.Sprintf("malformed event %q: %v", e.Data, e.Err)
+}
+
+func (e *MalformedEventError) Unwrap() error { return e.Err }
+
+// maxEventSize bounds a single SSE line. The final event echoes the prompt
+// and generation settings, so long inputs easily outgrow bufio.Scanner's 64KB
+// default, as do events carrying token probabilities.
+const maxEventSize = 4 << 20
+
+// postStream POSTs body as JSON to path and returns the response once llama-server
+// has accepted it. Non-200 responses are turned into a *ServerError when the
+// body carries one.
+func (s *Server) postStream(ctx context.Context, path string, body any) (*http.Response, error) {
+	jsonReq, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+path, bytes.NewReader(jsonReq))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+func responseError(resp *http.Response) error {
+	var payload struct {
+		Error *ServerError `json:"error"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, &payload) == nil && payload.Error != nil {
+		return payload.Error
+	}
+	return fmt.Errorf("server returned status %d", resp.StatusCode)
+}
+
+// readEvents feeds the data payload of every server-sent event in r to handle
+// until handle reports done. llama-server's "error: " events become a
+// *ServerError and a stream that ends early yields ErrIncompleteStream.
+func readEvents(r io.Reader, handle func(data []byte) (done bool, err error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxEventSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		if data, ok := bytes.CutPrefix(line, []byte("error: ")); ok {
+			return parseErrorEvent(data)
+		}
+		data, ok := bytes.CutPrefix(line, []byte("data: "))
+		if !ok {
+			continue
+		}
+		if string(data) == "[DONE]" {
+			return nil
+		}
+		done, err := handle(data)
+		if err != nil || done {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrIncompleteStream, err)
+	}
+	return ErrIncompleteStream
+}
+
+func parseErrorEvent(data []byte) error {
+	var payload struct {
+		Error *ServerError `json:"error"`
+	}
+	if err := json.Unmarshal(data, &payload); err == nil && payload.Error != nil {
+		return payload.Error
+	}
+	var serverErr ServerError
+	if err := json.Unmarshal(data, &serverErr); err != nil {
+		return &MalformedEventError{Data: string(data), Err: err}
+	}
+	return &serverErr
+}
+
+// send delivers v unless ctx is cancelled first. A send that can complete
+// immediately always wins, so final error events aren't lost to cancellation.
+func send[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	default:
+	}
+	select {
+	case ch <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,177 @@
+package llama
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EditResult is the structured answer a model produces when constrained by
+// EditResultSchema.
+type EditResult struct {
+	EditedText string `json:"edited_text"`
+	Edits      []Edit `json:"edits"`
+}
+
+type Edit struct {
+	Original    string `json:"original"`
+	Replacement string `json:"replacement"`
+	Category    string `json:"category"`
+	Explanation string `json:"explanation"`
+}
+
+// EditCategories are the values allowed in Edit.Category.
+var EditCategories = []string{
+	"grammar", "spelling", "punctuation", "word-choice", "clarity", "coherence",
+	"fluency", "formality", "neutrality", "simplification", "paraphrase", "other",
+}
+
+// EditResultSchema is the JSON schema sent as json_schema to force the model
+// into an EditResult. edited_text comes first so it can be shown while the
+// rest of the object is still streaming.
+var EditResultSchema = mustMarshal(map[string]any{
+	"type": "object",
+	"properties": orderedProps{
+		{"edited_text", map[string]any{"type": "string"}},
+		{"edits", map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": orderedProps{
+					{"original", map[string]any{"type": "string"}},
+					{"replacement", map[string]any{"type": "string"}},
+					{"category", map[string]any{"type": "string", "enum": EditCategories}},
+					{"explanation", map[string]any{"type": "string"}},
+				},
+				"required": []string{"original", "replacement", "category", "explanation"},
+			},
+		}},
+	},
+	"required": []string{"edited_text", "edits"},
+})
+
+// orderedProps marshals as a JSON object whose keys keep their order, which
+// llama-server follows when turning a schema into a grammar.
+type orderedProps []struct {
+	name   string
+	schema any
+}
+
+func (p orderedProps) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, prop := range p {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		schema, err := json.Marshal(prop.schema)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(strconv.Quote(prop.name))
+		b.WriteByte(':')
+		b.Write(schema)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+func mustMarshal(v any) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// EditDecoder accumulates a streamed EditResult. EditedText can be called at
+// any point to get as much of edited_text as has arrived; Result decodes the
+// whole object once the stream is done.
+type EditDecoder struct {
+	buf strings.Builder
+}
+
+func (d *EditDecoder) Write(chunk string) {
+	d.buf.WriteString(chunk)
+}
+
+// Raw returns everything written so far.
+func (d *EditDecoder) Raw() string {
+	return d.buf.String()
+}
+
+// EditedText returns the (possibly still incomplete) value of edited_text.
+func (d *EditDecoder) EditedText() string {
+	raw := d.buf.String()
+	i := strings.Index(raw, `"edited_text"`)
+	if i < 0 {
+		return ""
+	}
+	rest := strings.TrimLeft(raw[i+len(`"edited_text"`):], " \t\r\n")
+	rest, ok := strings.CutPrefix(rest, ":")
+	if !ok {
+		return ""
+	}
+	rest, ok = strings.CutPrefix(strings.TrimLeft(rest, " \t\r\n"), `"`)
+	if !ok {
+		return ""
+	}
+	return partialJSONString(rest)
+}
+
+// Result decodes the complete EditResult.
+func (d *EditDecoder) Result() (EditResult, error) {
+	var result EditResult
+	if err := json.Unmarshal([]byte(d.buf.String()), &result); err != nil {
+		return result, fmt.Errorf("model returned invalid edit JSON: %w", err)
+	}
+	return result, nil
+}
+
+func isHighSurrogate(hex string) bool {
+	v, err := strconv.ParseUint(hex, 16, 16)
+	return err == nil && v >= 0xD800 && v <= 0xDBFF
+}
+
+// partialJSONString decodes the body of a JSON string (after the opening
+// quote) up to its closing quote or, if it hasn't arrived yet, up to the last
+// complete character.
+func partialJSONString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String()
+		case c != '\\':
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && !utf8.FullRuneInString(s[i:]) {
+				return b.String()
+			}
+			b.WriteString(s[i : i+size])
+			i += size
+			continue
+		case i+1 >= len(s):
+			return b.String()
+		}
+		n := 2 // length of the escape sequence
+		if s[i+1] == 'u' {
+			n = 6
+			if i+6 <= len(s) && isHighSurrogate(s[i+2:i+6]) {
+				n = 12 // wait for the low half of the pair
+			}
+		}
+		if i+n > len(s) {
+			return b.String()
+		}
+		var r string
+		if err := json.Unmarshal([]byte(`"`+s[i:i+n]+`"`), &r); err != nil {
+			return b.String()
+		}
+		b.WriteString(r)
+		i += n
+	}
+	return b.String()
+}
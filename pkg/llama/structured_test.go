@@ -0,0 +1,56 @@
+package llama
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEditResultSchema(t *testing.T) {
+	var schema map[string]any
+	if err := json.Unmarshal(EditResultSchema, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	// edited_text must come first for EditDecoder.EditedText to be useful mid-stream
+	if i, j := strings.Index(string(EditResultSchema), `"edited_text"`), strings.Index(string(EditResultSchema), `"edits"`); i > j {
+		t.Errorf("edited_text should precede edits in %s", EditResultSchema)
+	}
+}
+
+func TestEditDecoder(t *testing.T) {
+	full := `{"edited_text": "I went to the \"store\"\nyesterday \u00e9 \ud83d\ude00 \/", "edits": [{"original": "has went", "replacement": "went", "category": "grammar", "explanation": "Past simple."}]}`
+	want := "I went to the \"store\"\nyesterday é 😀 /"
+
+	var d EditDecoder
+	var prev string
+	for i := 0; i < len(full); i++ {
+		d.Write(full[i : i+1])
+		got := d.EditedText()
+		if !strings.HasPrefix(want, got) {
+			t.Fatalf("after %q EditedText() = %q, not a prefix of %q", full[:i+1], got, want)
+		}
+		if len(got) < len(prev) {
+			t.Fatalf("EditedText went backwards: %q -> %q", prev, got)
+		}
+		prev = got
+	}
+	if prev != want {
+		t.Errorf("EditedText() = %q, want %q", prev, want)
+	}
+
+	result, err := d.Result()
+	if err != nil {
+		t.Fatalf("Result: %v", err)
+	}
+	if result.EditedText != want || len(result.Edits) != 1 || result.Edits[0].Category != "grammar" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestEditDecoderInvalid(t *testing.T) {
+	var d EditDecoder
+	d.Write(`{"edited_text": "trunc`)
+	if _, err := d.Result(); err == nil {
+		t.Error("expected an error for truncated JSON")
+	}
+}
@@ -0,0 +1,383 @@
+package llama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Restart knobs, variables so tests can shorten them.
+var (
+	restartBackoff    = time.Second
+	maxRestartBackoff = 30 * time.Second
+	// a server that stayed up this long has its crash count reset
+	stableUptime = time.Minute
+	// how long a failed stream waits to find out whether the server died
+	crashGracePeriod = 2 * time.Second
+)
+
+// ErrServerCrashed wraps the error of a stream that failed because
+// llama-server died while serving it.
+var ErrServerCrashed = errors.New("llama-server crashed")
+
+// errSupervisorStopped is returned for requests made after the supervisor
+// stopped or gave up restarting.
+var errSupervisorStopped = errors.New("llama-server is not running")
+
+// DefaultMaxRestarts is the SupervisorOptions.MaxRestarts of a negative value.
+const DefaultMaxRestarts = 5
+
+// SupervisorOptions tune how a Supervisor deals with crashes.
+type SupervisorOptions struct {
+	// MaxRestarts is how many restarts in a row are attempted before giving
+	// up, 0 for none. Negative values use DefaultMaxRestarts.
+	MaxRestarts int
+	// RetryInference re-sends a request whose server crashed mid-stream once
+	// the replacement is ready. The first event of the retried stream has
+	// InferenceResp.Retry set.
+	RetryInference bool
+}
+
+// Supervisor is a Backend that keeps llama-server running for a whole
+// session. When the process dies it starts a new one with exponential
+// backoff, reporting the restart through StatusUpdates, which unlike
+// Server's stays open until Stop (or until it gives up). Requests made while
+// restarting wait for the new server.
+type Supervisor struct {
+	newServer func() *Server
+	opts      SupervisorOptions
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	statuses chan ServerStatus
+	done     chan struct{} // closed once run has returned
+
+	mu     sync.Mutex
+	proc   *Server       // most recently launched server
+	server *Server       // healthy server, nil while (re)starting
+	ready  chan struct{} // closed when server is set
+}
+
+var _ Backend = (*Supervisor)(nil)
+
+// NewSupervisor returns a Supervisor that launches servers made by newServer.
+// newServer is called again for every restart, as a Server can only be
+// started once.
+func NewSupervisor(newServer func() *Server, opts SupervisorOptions) *Supervisor {
+	if opts.MaxRestarts < 0 {
+		opts.MaxRestarts = DefaultMaxRestarts
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{
+		newServer: newServer,
+		opts:      opts,
+		ctx:       ctx,
+		cancel:    cancel,
+		statuses:  make(chan ServerStatus, 32),
+		ready:     make(chan struct{}),
+	}
+}
+
+// Start launches the first server. Like Server.Start it does not wait for it
+// to be ready.
+func (s *Supervisor) Start() error {
+	server := s.newServer()
+	if err := server.Start(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.proc = server
+	s.done = make(chan struct{})
+	s.mu.Unlock()
+	go s.run(server)
+	return nil
+}
+
+// StatusUpdates reports startup progress, a Ready status each time a server
+// becomes healthy, and crashes and restarts in between. It is closed after
+// an error status (a failed first start, or too many restarts) or Stop. It
+// should only be called once.
+func (s *Supervisor) StatusUpdates(ctx context.Context) <-chan ServerStatus {
+	out := make(chan ServerStatus, 10)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case status, ok := <-s.statuses:
+				if !ok || !send(ctx, out, status) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// run supervises server and its replacements until Stop or giving up.
+func (s *Supervisor) run(server *Server) {
+	defer close(s.done)
+	defer close(s.statuses)
+
+	// a failing first start is reported as is, like a plain Server would
+	if ok, _ := s.startup(server, false); !ok {
+		return
+	}
+	crashes := 0
+	for {
+		upSince := time.Now()
+		s.setReady(server)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-server.exited:
+		}
+		s.setReady(nil)
+		if time.Since(upSince) >= stableUptime {
+			crashes = 0
+		}
+
+		reason := fmt.Sprintf("llama-server crashed (%v)", server.waitErr)
+		for {
+			crashes++
+			if s.opts.MaxRestarts == 0 {
+				s.emit(ServerStatus{Message: reason + ", restarts are off", IsError: true})
+				return
+			}
+			if crashes > s.opts.MaxRestarts {
+				s.emit(ServerStatus{Message: fmt.Sprintf("%s, giving up after %d restarts", reason, s.opts.MaxRestarts), IsError: true})
+				return
+			}
+			delay := min(restartBackoff<<(crashes-1), maxRestartBackoff)
+			s.emit(ServerStatus{Message: fmt.Sprintf("%s, restarting in %s (attempt %d/%d)", reason, delay, crashes, s.opts.MaxRestarts)})
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			server = s.newServer()
+			if err := server.Start(); err != nil {
+				reason = err.Error()
+				continue
+			}
+			s.mu.Lock()
+			s.proc = server
+			s.mu.Unlock()
+			ok, msg := s.startup(server, true)
+			if ok {
+				break
+			}
+			if s.ctx.Err() != nil {
+				return
+			}
+			server.Stop()
+			reason = msg
+		}
+	}
+}
+
+// startup forwards server's statuses until it is ready. Errors are forwarded
+// on the first start; on restarts they are returned instead so the restart
+// can be retried.
+func (s *Supervisor) startup(server *Server, restarting bool) (ok bool, errMsg string) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	statuses := server.StatusUpdates(ctx)
+	defer func() {
+		go func() {
+			for range statuses {
+			}
+		}()
+	}()
+	for status := range statuses {
+		if status.IsError {
+			if !restarting {
+				s.emit(status)
+			}
+			return false, status.Message
+		}
+		if status.Ready {
+			continue // announced by setReady
+		}
+		s.emit(status)
+	}
+	return s.ctx.Err() == nil, ""
+}
+
+// setReady makes server the one requests go to, or marks the supervisor as
+// restarting for a nil server.
+func (s *Supervisor) setReady(server *Server) {
+	s.mu.Lock()
+	if server == nil {
+		if s.server != nil {
+			s.ready = make(chan struct{})
+		}
+	} else {
+		close(s.ready)
+	}
+	s.server = server
+	s.mu.Unlock()
+	if server != nil {
+		s.emit(ServerStatus{Message: "Server is ready", Ready: true})
+	}
+}
+
+func (s *Supervisor) emit(status ServerStatus) {
+	send(s.ctx, s.statuses, status)
+}
+
+// awaitServer returns the healthy server, waiting for a restart to finish.
+// crashed, if not nil, is a server known to be dead that run may not have
+// noticed yet.
+func (s *Supervisor) awaitServer(ctx context.Context, crashed *Server) (*Server, error) {
+	for {
+		s.mu.Lock()
+		server, ready, done := s.server, s.ready, s.done
+		s.mu.Unlock()
+		if server != nil && server != crashed {
+			return server, nil
+		}
+		if done == nil {
+			return nil, errSupervisorStopped
+		}
+		if server != nil {
+			ready = nil // closed, so wait for run to notice the crash
+		}
+		select {
+		case <-ready:
+		case <-time.After(healthPollInterval):
+		case <-done:
+			return nil, errSupervisorStopped
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Inference streams the completion for req from the current server, see
+// SupervisorOptions.RetryInference for what happens if it crashes.
+func (s *Supervisor) Inference(ctx context.Context, req InferenceReq) (<-chan InferenceResp, error) {
+	return s.stream(ctx, func(server *Server) (<-chan InferenceResp, error) {
+		return server.Inference(ctx, req)
+	})
+}
+
+// Chat streams the reply to a conversation from the current server, see
+// SupervisorOptions.RetryInference for what happens if it crashes.
+func (s *Supervisor) Chat(ctx context.Context, req ChatReq) (<-chan InferenceResp, error) {
+	return s.stream(ctx, func(server *Server) (<-chan InferenceResp, error) {
+		return server.Chat(ctx, req)
+	})
+}
+
+func (s *Supervisor) stream(ctx context.Context, call func(*Server) (<-chan InferenceResp, error)) (<-chan InferenceResp, error) {
+	server, err := s.awaitServer(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	events, err := call(server)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan InferenceResp, 100)
+	go func() {
+		defer close(out)
+		retry := false
+		for {
+			failed, ok := forwardStream(ctx, events, out, retry)
+			if !ok || failed == nil {
+				return
+			}
+			// an error reply (*ServerError) comes from a server that is up,
+			// only a broken stream may mean it died
+			if ctx.Err() == nil && errors.Is(failed.Err, ErrIncompleteStream) && crashed(server) {
+				if s.opts.RetryInference && !retry {
+					if next, err := s.awaitServer(ctx, server); err == nil {
+						if events, err = call(next); err == nil {
+							server, retry = next, true
+							continue
+						}
+					}
+				}
+				failed.Err = fmt.Errorf("%w: %w", ErrServerCrashed, failed.Err)
+			}
+			send(ctx, out, *failed)
+			return
+		}
+	}()
+	return out, nil
+}
+
+// forwardStream copies events to out until the stream ends, marking the
+// first event as a retry if asked to. A failed stream's error event is
+// returned instead of forwarded; ok is false if ctx ended first.
+func forwardStream(ctx context.Context, events <-chan InferenceResp, out chan<- InferenceResp, retry bool) (failed *InferenceResp, ok bool) {
+	for event := range events {
+		if event.Err != nil {
+			return &event, true
+		}
+		event.Retry, retry = retry, false
+		if !send(ctx, out, event) {
+			return nil, false
+		}
+	}
+	return nil, true
+}
+
+// crashed reports whether server exited, giving it crashGracePeriod since a
+// stream usually breaks before the process is reaped.
+func crashed(server *Server) bool {
+	if server.exited == nil {
+		return false
+	}
+	select {
+	case <-server.exited:
+		return true
+	case <-time.After(crashGracePeriod):
+		return false
+	}
+}
+
+// Stop stops supervising and shuts down the current server.
+func (s *Supervisor) Stop() {
+	s.cancel()
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc != nil {
+		proc.Stop()
+	}
+}
+
+// Wait blocks until the most recently launched server exits and returns its
+// exit status.
+func (s *Supervisor) Wait() error {
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc == nil {
+		return nil
+	}
+	return proc.Wait()
+}
+
+// CommandLine returns the command the current server was launched with.
+func (s *Supervisor) CommandLine() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.proc == nil {
+		return ""
+	}
+	return s.proc.CommandLine()
+}
@@ -0,0 +1,262 @@
+//go:build unix
+
+package llama
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("NOMODIT_FAKE_LLAMA_SERVER") != "" {
+		fakeLlamaServer()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// fakeLlamaServer runs when the test binary is launched as llama-server: it
+// serves a llamatest fake on the --port it was given, so supervisors have a
+// real process to lose.
+func fakeLlamaServer() {
+	var port string
+	for i, arg := range os.Args {
+		if arg == "--port" && i+1 < len(os.Args) {
+			port = os.Args[i+1]
+		}
+	}
+	delay, _ := time.ParseDuration(os.Getenv("NOMODIT_FAKE_TOKEN_DELAY"))
+	var events []string
+	if e := os.Getenv("NOMODIT_FAKE_EVENTS"); e != "" {
+		events = strings.Split(e, "\n")
+	}
+	fake := llamatest.NewServer(llamatest.Config{
+		Tokens:     strings.Split(os.Getenv("NOMODIT_FAKE_TOKENS"), ","),
+		Events:     events,
+		TokenDelay: delay,
+	})
+	target, _ := url.Parse(fake.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.FlushInterval = -1
+	if err := http.ListenAndServe("127.0.0.1:"+port, proxy); err != nil {
+		os.Exit(1)
+	}
+}
+
+// newTestSupervisor returns a supervisor whose servers are copies of the test
+// binary acting as llama-server. Once broken is set, new servers fail to start.
+func newTestSupervisor(t *testing.T, opts SupervisorOptions, tokens []string, delay time.Duration, broken *atomic.Bool) *Supervisor {
+	t.Helper()
+	saved := restartBackoff
+	restartBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { restartBackoff = saved })
+
+	t.Setenv("NOMODIT_FAKE_LLAMA_SERVER", "1")
+	t.Setenv("NOMODIT_FAKE_TOKENS", strings.Join(tokens, ","))
+	t.Setenv("NOMODIT_FAKE_TOKEN_DELAY", delay.String())
+	model := filepath.Join(t.TempDir(), "fake.gguf")
+	if err := os.WriteFile(model, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	sup := NewSupervisor(func() *Server {
+		if broken != nil && broken.Load() {
+			return NewServerWithOptions(model, "", Options{Binary: missing})
+		}
+		return NewServerWithOptions(model, "", Options{Binary: binary})
+	}, opts)
+	if err := sup.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(sup.Stop)
+	return sup
+}
+
+// nextReady reads statuses up to the next Ready one, returning the messages seen.
+func nextReady(t *testing.T, statuses <-chan ServerStatus) []string {
+	t.Helper()
+	var messages []string
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case status, ok := <-statuses:
+			if !ok {
+				t.Fatalf("statuses closed before ready: %q", messages)
+			}
+			if status.IsError {
+				t.Fatalf("error status %q after %q", status.Message, messages)
+			}
+			if status.Ready {
+				return messages
+			}
+			messages = append(messages, status.Message)
+		case <-timeout:
+			t.Fatalf("not ready in time: %q", messages)
+		}
+	}
+}
+
+func crash(t *testing.T, sup *Supervisor) {
+	t.Helper()
+	sup.mu.Lock()
+	proc := sup.proc
+	sup.mu.Unlock()
+	if err := proc.llamaCmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSupervisorRestartsCrashedServer(t *testing.T) {
+	sup := newTestSupervisor(t, SupervisorOptions{MaxRestarts: -1}, []string{"ok"}, 0, nil)
+	statuses := sup.StatusUpdates(context.Background())
+	nextReady(t, statuses)
+	first := sup.CommandLine()
+
+	crash(t, sup)
+	messages := nextReady(t, statuses)
+	if len(messages) == 0 || !strings.Contains(messages[0], "llama-server crashed") || !strings.Contains(messages[0], "restarting in 10ms (attempt 1/5)") {
+		t.Errorf("restart statuses = %q", messages)
+	}
+	if sup.CommandLine() == first {
+		t.Error("no new server was launched")
+	}
+
+	events, err := sup.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Inference after restart: %v", err)
+	}
+	if content, err := drain(t, events); err != nil || content != "ok" {
+		t.Errorf("got %q, %v after restart", content, err)
+	}
+}
+
+func TestSupervisorRetriesInference(t *testing.T) {
+	tokens := []string{"a", "b", "c", "d", "e"}
+	sup := newTestSupervisor(t, SupervisorOptions{MaxRestarts: -1, RetryInference: true}, tokens, 100*time.Millisecond, nil)
+	statuses := sup.StatusUpdates(context.Background())
+	nextReady(t, statuses)
+	go func() {
+		for range statuses {
+		}
+	}()
+
+	events, err := sup.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	if first := <-events; first.Content != "a" {
+		t.Fatalf("first event = %+v", first)
+	}
+	crash(t, sup)
+
+	var content strings.Builder
+	retried := false
+	for event := range events {
+		if event.Err != nil {
+			t.Fatalf("stream failed: %v", event.Err)
+		}
+		if event.Retry {
+			retried = true
+			content.Reset()
+		}
+		content.WriteString(event.Content)
+	}
+	if !retried || content.String() != "abcde" {
+		t.Errorf("retried %v, content %q; want a retry with the whole answer", retried, content.String())
+	}
+}
+
+func TestSupervisorCrashWithoutRetry(t *testing.T) {
+	sup := newTestSupervisor(t, SupervisorOptions{MaxRestarts: -1}, []string{"a", "b", "c"}, 100*time.Millisecond, nil)
+	statuses := sup.StatusUpdates(context.Background())
+	nextReady(t, statuses)
+	go func() {
+		for range statuses {
+		}
+	}()
+
+	events, err := sup.Inference(context.Background(), InferenceReq{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	<-events
+	crash(t, sup)
+	if _, err := drain(t, events); !errors.Is(err, ErrServerCrashed) {
+		t.Errorf("err = %v, want ErrServerCrashed", err)
+	}
+}
+
+func TestSupervisorServerErrorIsNotACrash(t *testing.T) {
+	t.Setenv("NOMODIT_FAKE_EVENTS", `{"content":"a","stop":false}`+"\n"+
+		`{"error":{"code":400,"message":"context overflow","type":"invalid_request_error"}}`)
+	sup := newTestSupervisor(t, SupervisorOptions{MaxRestarts: -1, RetryInference: true}, nil, 0, nil)
+	nextReady(t, sup.StatusUpdates(context.Background()))
+
+	start := time.Now()
+	events, err := sup.Inference(context.Background(), InferenceReq{})
+	if err != nil {
+		t.Fatalf("Inference: %v", err)
+	}
+	_, err = drain(t, events)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || errors.Is(err, ErrServerCrashed) {
+		t.Errorf("err = %v, want the server's error as is", err)
+	}
+	if elapsed := time.Since(start); elapsed >= crashGracePeriod {
+		t.Errorf("the error took %s, waiting to see whether the server crashed", elapsed)
+	}
+}
+
+func TestSupervisorGivesUp(t *testing.T) {
+	var broken atomic.Bool
+	sup := newTestSupervisor(t, SupervisorOptions{MaxRestarts: 2}, []string{"ok"}, 0, &broken)
+	broken.Store(true)
+	statuses := sup.StatusUpdates(context.Background())
+	nextReady(t, statuses)
+
+	crash(t, sup)
+	var last ServerStatus
+	for status := range statuses {
+		last = status
+	}
+	if !last.IsError || !strings.Contains(last.Message, "giving up after 2 restarts") {
+		t.Errorf("last status = %+v, want giving up", last)
+	}
+	if _, err := sup.Inference(context.Background(), InferenceReq{}); err == nil {
+		t.Error("Inference succeeded after the supervisor gave up")
+	}
+}
+
+func TestSupervisorNoRestarts(t *testing.T) {
+	sup := newTestSupervisor(t, SupervisorOptions{MaxRestarts: 0}, []string{"ok"}, 0, nil)
+	statuses := sup.StatusUpdates(context.Background())
+	nextReady(t, statuses)
+
+	crash(t, sup)
+	var last ServerStatus
+	for status := range statuses {
+		if strings.Contains(status.Message, "restarting") {
+			t.Errorf("restarted with MaxRestarts 0: %+v", status)
+		}
+		last = status
+	}
+	if !last.IsError || !strings.Contains(last.Message, "restarts are off") {
+		t.Errorf("last status = %+v, want an error", last)
+	}
+}
@@ -0,0 +1,199 @@
+package llama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Tokenizer is implemented by backends that can count tokens the way the
+// loaded model does, so callers can check that a request fits before
+// sending it.
+type Tokenizer interface {
+	// Tokenize returns the token ids of text, without BOS/EOS.
+	Tokenize(ctx context.Context, text string) ([]int, error)
+	// Detokenize turns token ids back into text.
+	Detokenize(ctx context.Context, tokens []int) (string, error)
+	// ContextSize returns the context window of one slot, in tokens.
+	ContextSize(ctx context.Context) (int, error)
+}
+
+var (
+	_ Tokenizer = (*Server)(nil)
+	_ Tokenizer = (*Supervisor)(nil)
+)
+
+// Reasoner is a backend that can tell whether its model reasons before it
+// answers. llama-server counts the reasoning against n_predict.
+type Reasoner interface {
+	Reasoning(ctx context.Context) (bool, error)
+}
+
+var (
+	_ Reasoner = (*Server)(nil)
+	_ Reasoner = (*Supervisor)(nil)
+	_ Reasoner = (*Scheduler)(nil)
+)
+
+// Tokenize returns the token ids of text using llama-server's /tokenize.
+func (s *Server) Tokenize(ctx context.Context, text string) ([]int, error) {
+	var resp struct {
+		Tokens []int `json:"tokens"`
+	}
+	err := s.call(ctx, http.MethodPost, "/tokenize", map[string]any{"content": text}, &resp)
+	return resp.Tokens, err
+}
+
+// Detokenize turns token ids back into text using llama-server's /detokenize.
+func (s *Server) Detokenize(ctx context.Context, tokens []int) (string, error) {
+	var resp struct {
+		Content string `json:"content"`
+	}
+	err := s.call(ctx, http.MethodPost, "/detokenize", map[string]any{"tokens": tokens}, &resp)
+	return resp.Content, err
+}
+
+// serverProps is the part of llama-server's /props nomodit uses.
+type serverProps struct {
+	NCtx     int `json:"n_ctx"`
+	Settings struct {
+		NCtx int `json:"n_ctx"`
+	} `json:"default_generation_settings"`
+	TotalSlots   int    `json:"total_slots"`
+	ChatTemplate string `json:"chat_template"`
+}
+
+// fetchProps returns llama-server's /props. The answer is cached, it can't
+// change without a restart.
+func (s *Server) fetchProps(ctx context.Context) (*serverProps, error) {
+	s.propsMu.Lock()
+	defer s.propsMu.Unlock()
+	if s.props != nil {
+		return s.props, nil
+	}
+	var props serverProps
+	if err := s.call(ctx, http.MethodGet, "/props", nil, &props); err != nil {
+		return nil, err
+	}
+	s.props = &props
+	return s.props, nil
+}
+
+// ContextSize returns n_ctx from llama-server's /props. With --parallel the
+// context is split between slots, and this is the size of one.
+func (s *Server) ContextSize(ctx context.Context) (int, error) {
+	props, err := s.fetchProps(ctx)
+	if err != nil {
+		return 0, err
+	}
+	nCtx := max(props.Settings.NCtx, props.NCtx)
+	if nCtx == 0 {
+		return 0, fmt.Errorf("llama-server did not report its context size")
+	}
+	return nCtx, nil
+}
+
+// Slots returns how many requests llama-server processes at once
+// (--parallel), from /props.
+func (s *Server) Slots(ctx context.Context) (int, error) {
+	props, err := s.fetchProps(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if props.TotalSlots == 0 {
+		return 0, fmt.Errorf("llama-server did not report its slot count")
+	}
+	return props.TotalSlots, nil
+}
+
+// Reasoning reports whether the model's chat template, from /props, has a
+// <think> or <reasoning> block for the model to fill before its answer.
+func (s *Server) Reasoning(ctx context.Context) (bool, error) {
+	props, err := s.fetchProps(ctx)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(props.ChatTemplate, "<think>") || strings.Contains(props.ChatTemplate, "<reasoning>"), nil
+}
+
+// call sends body (if not nil) as JSON to path and decodes the JSON answer
+// into out. Non-200 responses are reported as by postStream.
+func (s *Server) call(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// Tokenize tokenizes text on the current server.
+func (s *Supervisor) Tokenize(ctx context.Context, text string) ([]int, error) {
+	server, err := s.awaitServer(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return server.Tokenize(ctx, text)
+}
+
+// Detokenize detokenizes tokens on the current server.
+func (s *Supervisor) Detokenize(ctx context.Context, tokens []int) (string, error) {
+	server, err := s.awaitServer(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	return server.Detokenize(ctx, tokens)
+}
+
+// ContextSize returns the context size of the current server.
+func (s *Supervisor) ContextSize(ctx context.Context) (int, error) {
+	server, err := s.awaitServer(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return server.ContextSize(ctx)
+}
+
+// Slots returns the slot count of the current server.
+func (s *Supervisor) Slots(ctx context.Context) (int, error) {
+	server, err := s.awaitServer(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return server.Slots(ctx)
+}
+
+// Reasoning reports whether the current server's model reasons.
+func (s *Supervisor) Reasoning(ctx context.Context) (bool, error) {
+	server, err := s.awaitServer(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	return server.Reasoning(ctx)
+}
@@ -0,0 +1,56 @@
+package llama
+
+import (
+	"context"
+	"testing"
+
+	"github.com/muzzlol/nomodit/pkg/llama/llamatest"
+)
+
+func TestTokenize(t *testing.T) {
+	s, _ := newFakeBackedServer(t, llamatest.Config{ContextSize: 2048}, true)
+	ctx := context.Background()
+
+	tokens, err := s.Tokenize(ctx, "the cat saw the dog")
+	if err != nil {
+		t.Fatalf("Tokenize: %v", err)
+	}
+	if len(tokens) != 5 || tokens[0] != tokens[3] {
+		t.Errorf("Tokenize = %v, want 5 tokens with 'the' repeated", tokens)
+	}
+	text, err := s.Detokenize(ctx, tokens)
+	if err != nil {
+		t.Fatalf("Detokenize: %v", err)
+	}
+	if text != "the cat saw the dog" {
+		t.Errorf("Detokenize = %q", text)
+	}
+
+	n, err := s.ContextSize(ctx)
+	if err != nil || n != 2048 {
+		t.Errorf("ContextSize = %d, %v; want 2048", n, err)
+	}
+}
+
+func TestReasoning(t *testing.T) {
+	ctx := context.Background()
+	for template, want := range map[string]bool{
+		"{{ messages }}": false,
+		"{% if thinking %}<think>\n{{ reasoning_content }}\n</think>{% endif %}": true,
+	} {
+		s, _ := newFakeBackedServer(t, llamatest.Config{ChatTemplate: template}, true)
+		if got, err := s.Reasoning(ctx); err != nil || got != want {
+			t.Errorf("Reasoning with template %q = %v, %v; want %v", template, got, err, want)
+		}
+	}
+}
+
+func TestTokenizeServerError(t *testing.T) {
+	s := NewRemoteServer("http://127.0.0.1:1")
+	if _, err := s.Tokenize(context.Background(), "hi"); err == nil {
+		t.Error("expected an error without a server")
+	}
+	if _, err := s.ContextSize(context.Background()); err == nil {
+		t.Error("expected an error without a server")
+	}
+}